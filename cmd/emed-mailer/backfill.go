@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/emed-appts/emed-mailer/internal/config"
+	"github.com/emed-appts/emed-mailer/internal/job"
+
+	"github.com/pkg/errors"
+	"github.com/robfig/cron/v3"
+	"github.com/urfave/cli/v2"
+)
+
+// window is a period reported by a single message
+type window struct {
+	from time.Time
+	to   time.Time
+}
+
+var backfillCommand = &cli.Command{
+	Name:  "backfill",
+	Usage: "resend the messages of a past period, e.g. after an outage",
+	Description: "Splits the period at the activations of the job schedules and sends a message per part,\n" +
+		"like the daemon would have done. The last runs of the jobs are kept.\n" +
+		"The mails of all parts must not exceed the [mail] RATE_LIMIT.\n" +
+		"Jobs of type patients are not backfilled.",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:     "from",
+			Usage:    "start of the period, e.g. \"2026-10-19 06:00\"",
+			Required: true,
+		},
+		&cli.StringFlag{
+			Name:     "to",
+			Usage:    "end of the period",
+			Required: true,
+		},
+		&cli.StringSliceFlag{
+			Name:  "job",
+			Usage: "names of the jobs to backfill, defaults to all jobs except type patients",
+		},
+		&cli.IntFlag{
+			Name:  "max",
+			Value: 100,
+			Usage: "maximum number of messages per job, protects against frequent schedules",
+		},
+	},
+	Action: backfill,
+}
+
+func backfill(ctx *cli.Context) error {
+	cfg, logWriter, err := loadCommandConfig()
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	defer logWriter.Close()
+	loc := cfg.Location()
+
+	from, err := parseTimeFlag(ctx, "from", loc)
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	to, err := parseTimeFlag(ctx, "to", loc)
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	if !from.Before(to) {
+		return cli.Exit("--from must be before --to", 1)
+	}
+	if to.After(time.Now()) {
+		return cli.Exit("--to must not be in the future", 1)
+	}
+
+	names, err := backfillJobs(cfg, ctx.StringSlice("job"))
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+
+	// check all jobs before sending anything
+	planned := map[string][]window{}
+	mails := 0
+	for _, name := range names {
+		jc, _ := cfg.FindJob(name)
+
+		split, ok := windows(jc.Schedule, from, to, ctx.Int("max"))
+		if !ok {
+			return cli.Exit(fmt.Sprintf("job %s: more messages than --max %d, shorten the period or raise --max", name, ctx.Int("max")), 1)
+		}
+		planned[name] = split
+		mails += len(planned[name]) * mailChannels(cfg, jc.ChannelNames())
+	}
+	// the mailer refuses mails beyond the rate limit, they would get lost halfway through
+	if cfg.Mail.RateLimit > 0 && mails > cfg.Mail.RateLimit {
+		return cli.Exit(fmt.Sprintf("%d mails exceed [mail] RATE_LIMIT %d, shorten the period, select fewer jobs or raise RATE_LIMIT", mails, cfg.Mail.RateLimit), 1)
+	}
+
+	svc, err := startServices(cfg)
+	if err != nil {
+		return cli.Exit(errors.Cause(err).Error(), 1)
+	}
+	defer svc.Close()
+
+	failed := 0
+	for _, name := range names {
+		// the changes of the period are read once and split into the windows
+		sched := newScheduler(svc.db, job.NewPeriodCollector(svc.scheduler.collector, from), svc.mailer)
+		j := sched.newJob(cfg, name, from, nil)
+
+		for _, w := range planned[name] {
+			count, err := j.RunWindow(w.from, w.to)
+			if err != nil {
+				failed++
+				fmt.Fprintf(ctx.App.Writer, "job %s: %s - %s: %v\n", name, displayTime(w.from, loc), displayTime(w.to, loc), err)
+				continue
+			}
+			fmt.Fprintf(ctx.App.Writer, "job %s: %s - %s: reported %d change(s)\n", name, displayTime(w.from, loc), displayTime(w.to, loc), count)
+		}
+	}
+
+	if failed > 0 {
+		return cli.Exit(fmt.Sprintf("%d message(s) failed", failed), 1)
+	}
+	return nil
+}
+
+// backfillJobs checks the selected jobs of `cfg`, without selection all jobs except type patients are selected
+// patients must not get notifications about past changes again
+func backfillJobs(cfg *config.Snapshot, selected []string) ([]string, error) {
+	if len(selected) == 0 {
+		var names []string
+		for _, j := range cfg.Jobs {
+			if j.Type != config.TypePatients {
+				names = append(names, j.Name)
+			}
+		}
+		if len(names) == 0 {
+			return nil, errors.New("no job to backfill configured")
+		}
+		return names, nil
+	}
+
+	for _, name := range selected {
+		j, ok := cfg.FindJob(name)
+		if !ok {
+			return nil, errors.Errorf("unknown job %q", name)
+		}
+		if j.Type == config.TypePatients {
+			return nil, errors.Errorf("job %s: jobs of type %s can not be backfilled", name, config.TypePatients)
+		}
+	}
+	return selected, nil
+}
+
+// mailChannels counts the channels of `names` which send mails
+func mailChannels(cfg *config.Snapshot, names []string) int {
+	count := 0
+	for _, name := range names {
+		if name == config.MailChannel {
+			count++
+			continue
+		}
+		for _, c := range cfg.Channels {
+			if c.Name == name && c.Type == config.ChannelEmail {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// windows splits the period from `from` until `to` at the activations of `schedule`
+// it stops and returns false once the period splits into more than `max` windows
+func windows(schedule cron.Schedule, from, to time.Time, max int) ([]window, bool) {
+	var split []window
+
+	start := from
+	for t := schedule.Next(from); !t.IsZero() && t.Before(to); t = schedule.Next(t) {
+		split = append(split, window{start, t})
+		if len(split) > max {
+			return nil, false
+		}
+		start = t
+	}
+	split = append(split, window{start, to})
+
+	return split, len(split) <= max
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/emed-appts/emed-mailer/internal/collector"
+	"github.com/emed-appts/emed-mailer/internal/config"
+	"github.com/emed-appts/emed-mailer/internal/job"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+var checkDBCommand = &cli.Command{
+	Name:  "check-db",
+	Usage: "check the database connection, schema and data",
+	Description: "Connects to the database of [db], checks that the appointment log and the patient table\n" +
+		"have the columns the collector reads with compatible types and prints the changes of the last days.\n" +
+		"Exported logs of TYPE csv are read and their changes of the last days printed.",
+	Flags: []cli.Flag{
+		&cli.IntFlag{
+			Name:  "days",
+			Value: 7,
+			Usage: "number of days of the sample query",
+		},
+		&cli.IntFlag{
+			Name:  "rows",
+			Value: 10,
+			Usage: "number of sample changes printed",
+		},
+	},
+	Action: checkDB,
+}
+
+func checkDB(ctx *cli.Context) error {
+	if err := config.Load(); err != nil {
+		return cli.Exit(fmt.Sprintf("%s: %v", config.Path, errors.Cause(err)), 1)
+	}
+	cfg := config.Current()
+	loc := cfg.Location()
+	w := ctx.App.Writer
+
+	if cfg.DB.Type == config.DBTypeCSV {
+		printResult(w, statusOK, "connect", "reading exported logs of "+cfg.DB.File)
+		if !sampleChanges(w, collector.NewCSV(cfg.DB.File, parseConfig(cfg)), ctx.Int("days"), ctx.Int("rows"), loc) {
+			return cli.Exit("\ndatabase check failed", 1)
+		}
+		fmt.Fprintln(w, "\ndatabase check passed")
+		return nil
+	}
+
+	if cfg.DB.Type == config.DBTypeWebhook {
+		wc := webhookConfig(cfg)
+		printResult(w, statusOK, "connect", "reading events received by the webhook from "+wc.File)
+		if !sampleChanges(w, collector.NewWebhook(wc, parseConfig(cfg)), ctx.Int("days"), ctx.Int("rows"), loc) {
+			return cli.Exit("\ndatabase check failed", 1)
+		}
+		fmt.Fprintln(w, "\ndatabase check passed")
+		return nil
+	}
+
+	start := time.Now()
+	db, err := collector.OpenSQL(dbConfig(cfg))
+	if err != nil {
+		printResult(w, statusFail, "connect", "error: "+err.Error())
+		return cli.Exit("\ndatabase check failed", 1)
+	}
+	defer db.Close()
+	name := cfg.DB.Database
+	if cfg.DB.Type == config.DBTypeSQLite {
+		name = cfg.DB.File
+	}
+	printResult(w, statusOK, "connect", fmt.Sprintf("connected to %s database %s in %s", cfg.DB.Type, name, time.Since(start).Round(time.Millisecond)))
+
+	ok := checkTable(w, db, "log", collector.LogTable, collector.LogColumns)
+
+	for _, j := range cfg.Jobs {
+		if j.UsesPatients() {
+			ok = checkTable(w, db, "patients", cfg.Patients.Table, collector.PatientColumns(patientConfig(cfg))) && ok
+			break
+		}
+	}
+
+	if !ok {
+		printResult(w, statusWarn, "sample", "skipped, the schema is not compatible")
+		return cli.Exit("\ndatabase check failed", 1)
+	}
+
+	if !sampleChanges(w, collector.New(db, parseConfig(cfg)), ctx.Int("days"), ctx.Int("rows"), loc) {
+		return cli.Exit("\ndatabase check failed", 1)
+	}
+
+	fmt.Fprintln(w, "\ndatabase check passed")
+	return nil
+}
+
+// checkTable prints the check of the `columns` of `table` and reports if all are compatible
+func checkTable(w io.Writer, db *collector.DB, name, table string, columns []collector.Column) bool {
+	checks, err := collector.CheckColumns(db, table, columns)
+	if err != nil {
+		printResult(w, statusFail, name, "error: "+err.Error())
+		return false
+	}
+
+	ok := true
+	lines := []string{"table " + table}
+	for _, c := range checks {
+		switch {
+		case c.Type == "":
+			ok = false
+			lines = append(lines, fmt.Sprintf("%s is missing", c.Name))
+		case !c.OK():
+			ok = false
+			lines = append(lines, fmt.Sprintf("%s is %s, expected one of %s", c.Name, c.Type, strings.Join(c.Types, ", ")))
+		default:
+			lines = append(lines, fmt.Sprintf("%s %s", c.Name, c.Type))
+		}
+	}
+
+	status := statusOK
+	if !ok {
+		status = statusFail
+	}
+	printResult(w, status, name, lines...)
+	return ok
+}
+
+// sampleChanges prints the latest of the changes of the last `days` days parsed like the daemon does
+func sampleChanges(w io.Writer, c job.Collector, days, rows int, loc *time.Location) bool {
+	since := time.Now().AddDate(0, 0, -days)
+
+	start := time.Now()
+	changes, err := c.CollectChangedAppts(since)
+	if err != nil {
+		printResult(w, statusFail, "sample", "error: "+err.Error())
+		return false
+	}
+
+	bookings, unparsed := 0, 0
+	for _, change := range changes {
+		switch {
+		case change.Error != "":
+			unparsed++
+		case change.IsBooking:
+			bookings++
+		}
+	}
+
+	lines := []string{fmt.Sprintf("%d change(s) within %d days in %s: %d booking(s), %d cancellation(s), %d not interpretable",
+		len(changes), days, time.Since(start).Round(time.Millisecond), bookings, len(changes)-bookings-unparsed, unparsed)}
+	if len(changes) > rows {
+		lines = append(lines, fmt.Sprintf("latest %d:", rows))
+		changes = changes[len(changes)-rows:]
+	}
+	for _, change := range changes {
+		if change.Error != "" {
+			lines = append(lines, fmt.Sprintf("%s  %-12s  %s  patient %d %s",
+				displayTime(change.Time, loc), "unparsed", change.Error, change.PatientID, change.PatientName))
+			continue
+		}
+
+		action := "cancellation"
+		if change.IsBooking {
+			action = "booking"
+		}
+		lines = append(lines, fmt.Sprintf("%s  %-12s  appointment %s  patient %d %s",
+			displayTime(change.Time, loc), action, displayTime(change.Appointment, loc), change.PatientID, change.PatientName))
+	}
+
+	status := statusOK
+	switch {
+	case len(changes) == 0:
+		status = statusWarn
+		lines = append(lines, "hint: no changes found, check that eTermin writes to this database")
+	case unparsed > 0:
+		status = statusWarn
+		lines = append(lines, "hint: entries which can not be interpreted are quarantined, check TIME_FORMATS of [db]")
+	}
+	printResult(w, status, "sample", lines...)
+	return true
+}
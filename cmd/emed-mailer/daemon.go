@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/emed-appts/emed-mailer/internal/collector"
+	"github.com/emed-appts/emed-mailer/internal/config"
+	"github.com/emed-appts/emed-mailer/internal/mailer"
+	"github.com/emed-appts/emed-mailer/internal/monitor"
+	"github.com/emed-appts/emed-mailer/internal/template"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v2"
+)
+
+// interval to check the config file for changes
+const configWatchInterval = 5 * time.Second
+
+// runDaemon runs the emed-mailer service until it gets stopped by a signal
+func runDaemon(ctx *cli.Context) error {
+	format := ctx.String("dry-run-format")
+	if err := checkFormat(format); err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+
+	// load config
+	err := config.Load()
+	if err != nil {
+		fmt.Fprintf(ctx.App.Writer, "\nCould not load configuration file.\n%v\n\n", errors.Cause(err))
+
+		cli.ShowAppHelp(ctx)
+		return cli.Exit("", 128)
+	}
+	cfg := config.Current()
+
+	// configure logger
+	logWriter, err := setupLogger(cfg)
+	if err != nil {
+		fmt.Fprintf(ctx.App.Writer, "\nCould not set up logging.\n%v\n\n", errors.Cause(err))
+
+		cli.ShowAppHelp(ctx)
+		return cli.Exit("", 128)
+	}
+	defer logWriter.Close()
+
+	logSettings(cfg)
+
+	svc, err := startServices(cfg)
+	if err != nil {
+		log.Fatal().
+			Msgf("%+v\n", err)
+	}
+	m := svc.mailer
+
+	// schedule jobs
+	sched := svc.scheduler
+	if ctx.Bool("dry-run") {
+		dir := ctx.String("dry-run-dir")
+		if dir == "" {
+			dir = filepath.Join(cfg.General.Root, "dry-run")
+		}
+		sched.dryRun = &dryRun{format: format, dir: dir}
+
+		log.Warn().
+			Str("dir", dir).
+			Msg("dry run, messages are written instead of sent")
+	}
+	sched.apply(cfg)
+	sched.Start()
+
+	// serve status and health endpoints if enabled
+	server := newStatusServer(monitor.New(map[string]monitor.Check{
+		"db": svc.checkDB,
+		"smtp": func(context.Context) error {
+			return m.Check()
+		},
+	}, sched.status))
+	if err := server.apply(cfg.HTTP.Listen); err != nil {
+		log.Fatal().
+			Msgf("%+v\n", err)
+	}
+
+	// receive events of booking portals if they are the source of appointments
+	webhookServer := newStatusServer(svc.webhook)
+	if svc.webhook != nil {
+		if err := svc.webhook.Open(); err != nil {
+			log.Fatal().
+				Msgf("%+v\n", err)
+		}
+		if err := webhookServer.apply(cfg.Webhook.Listen); err != nil {
+			log.Fatal().
+				Msgf("%+v\n", err)
+		}
+	}
+
+	// reload applies a changed configuration
+	// the jobs keep their state, so no appointment changes get lost
+	reload := func() {
+		previous := cfg
+
+		if err := config.Load(); err != nil {
+			log.Error().
+				Msgf("configuration reload rejected, keeping previous configuration\n%v", errors.Cause(err))
+			return
+		}
+		cfg = config.Current()
+
+		if err := logWriter.configure(cfg); err != nil {
+			log.Error().
+				Err(err).
+				Msg("could not reconfigure logger")
+		}
+
+		m.Reconfigure(mailerConfig(cfg))
+		template.SetDir(cfg.General.Templates)
+		template.SetLocation(cfg.Location())
+		sched.apply(cfg)
+		if err := server.apply(cfg.HTTP.Listen); err != nil {
+			log.Error().
+				Err(err).
+				Msg("could not start http server")
+		}
+
+		if *cfg.DB != *previous.DB {
+			log.Warn().
+				Msg("database settings changed, restart the service to apply them")
+		}
+		if *cfg.Webhook != *previous.Webhook {
+			log.Warn().
+				Msg("webhook settings changed, restart the service to apply them")
+		}
+		if txtRulesKey(cfg) != txtRulesKey(previous) {
+			log.Warn().
+				Msg("txt rules changed, restart the service to apply them")
+		}
+
+		log.Info().
+			Msg("configuration reloaded")
+		logSettings(cfg)
+	}
+
+	changes := config.Watch(configWatchInterval, svc.stop)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+loop:
+	for {
+		select {
+		case sig := <-sigs:
+			if sig != syscall.SIGHUP {
+				break loop
+			}
+			log.Info().
+				Msg("received SIGHUP, reloading configuration")
+			reload()
+		case <-changes:
+			log.Info().
+				Msg("configuration file changed, reloading configuration")
+			reload()
+		}
+	}
+
+	signal.Stop(sigs)
+	server.Stop()
+	webhookServer.Stop()
+	sched.Stop()
+	svc.Close()
+
+	return nil
+}
+
+// dbConfig returns the collector settings of `cfg`
+func dbConfig(cfg *config.Snapshot) collector.DBConfig {
+	return collector.DBConfig{
+		Type:     cfg.DB.Type,
+		DSN:      cfg.DB.DSN,
+		File:     cfg.DB.File,
+		Server:   cfg.DB.Server,
+		Instance: cfg.DB.Instance,
+		Port:     cfg.DB.Port,
+		Auth:     cfg.DB.Auth,
+		Domain:   cfg.DB.Domain,
+		User:     cfg.DB.User,
+		Password: cfg.DB.Password,
+		SPN:      cfg.DB.SPN,
+		Database: cfg.DB.Database,
+	}
+}
+
+// parseConfig returns the interpretation of the appointment log of `cfg`
+func parseConfig(cfg *config.Snapshot) collector.ParseConfig {
+	parse := collector.ParseConfig{
+		TimeFormats: cfg.DB.TimeFormatList(),
+		Location:    cfg.DB.Location(),
+	}
+	for _, r := range cfg.TxtRules {
+		parse.TxtRules = append(parse.TxtRules, &collector.TxtRule{
+			Name:            r.Name,
+			Pattern:         r.Regexp,
+			BirthDateFormat: r.BirthDateFormat,
+		})
+	}
+	return parse
+}
+
+// patientConfig returns the lookup of patients of `cfg`
+func patientConfig(cfg *config.Snapshot) collector.PatientConfig {
+	return collector.PatientConfig{
+		Table:        cfg.Patients.Table,
+		IDColumn:     cfg.Patients.IDColumn,
+		EmailColumn:  cfg.Patients.EmailColumn,
+		OptOutColumn: cfg.Patients.OptOutColumn,
+
+		PhoneColumn:      cfg.Patients.PhoneColumn,
+		InsuranceColumn:  cfg.Patients.InsuranceColumn,
+		NewPatientColumn: cfg.Patients.NewPatientColumn,
+	}
+}
+
+// txtRulesKey describes the txt rules of `cfg` to detect changes
+func txtRulesKey(cfg *config.Snapshot) string {
+	var key strings.Builder
+	for _, r := range cfg.TxtRules {
+		fmt.Fprintf(&key, "%s\x00%s\x00%s\x00", r.Name, r.Pattern, r.BirthDateFormat)
+	}
+	return key.String()
+}
+
+// mailerConfig returns the mailer settings of `cfg`
+func mailerConfig(cfg *config.Snapshot) mailer.Config {
+	return mailer.Config{
+		Server:   cfg.Mail.Server,
+		Port:     cfg.Mail.Port,
+		User:     cfg.Mail.User,
+		Password: cfg.Mail.Password,
+
+		From:    cfg.Mail.From,
+		To:      cfg.Mail.To,
+		Subject: cfg.Mail.Subject,
+
+		RateLimit: cfg.Mail.RateLimit,
+	}
+}
+
+// alertMailerConfig returns the mailer settings of alerts of `cfg`
+// the mail server of [mail] is used if [alert] has none
+func alertMailerConfig(cfg *config.Snapshot) mailer.Config {
+	mc := mailerConfig(cfg)
+	mc.To = cfg.Alert.To
+	mc.Subject = ""
+
+	if cfg.Alert.Server != "" {
+		mc.Server = cfg.Alert.Server
+		mc.Port = cfg.Alert.Port
+		mc.User = cfg.Alert.User
+		mc.Password = cfg.Alert.Password
+	}
+	if cfg.Alert.From != "" {
+		mc.From = cfg.Alert.From
+	}
+
+	return mc
+}
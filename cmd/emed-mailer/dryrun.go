@@ -0,0 +1,61 @@
+package main
+
+import (
+	"io"
+	"time"
+
+	"github.com/emed-appts/emed-mailer/internal/config"
+	"github.com/emed-appts/emed-mailer/internal/job"
+	"github.com/emed-appts/emed-mailer/internal/notifier"
+
+	"github.com/pkg/errors"
+)
+
+// dryRun writes the messages of the jobs instead of sending them
+type dryRun struct {
+	// format of the messages, html or eml
+	format string
+	// dir receives a file per message, out is used if empty
+	dir string
+	out io.Writer
+}
+
+// writer creates the writer replacing the channels of job `name` of `cfg`
+// `to` and `subject` are the recipients and subject of eml, empty values fall back to [mail]
+func (d *dryRun) writer(cfg *config.Snapshot, name, to, subject string) *notifier.Writer {
+	mc := mailerConfig(cfg)
+	if to != "" {
+		mc.To = to
+	}
+	if subject != "" {
+		mc.Subject = subject
+	}
+
+	return notifier.NewWriter(notifier.WriterConfig{
+		Name:   name,
+		Format: d.format,
+		Dir:    d.dir,
+		Out:    d.out,
+		Mail:   mc,
+	})
+}
+
+// readOnlyLedger skips notifications sent already but does not record written ones,
+// so a dry run does not suppress the real notifications
+type readOnlyLedger struct {
+	job.Ledger
+}
+
+// Record does nothing
+func (l readOnlyLedger) Record(string, time.Time) error {
+	return nil
+}
+
+// checkFormat checks the output format of a dry run
+func checkFormat(format string) error {
+	switch format {
+	case notifier.FormatHTML, notifier.FormatEML:
+		return nil
+	}
+	return errors.Errorf("invalid format %q, expected %s or %s", format, notifier.FormatHTML, notifier.FormatEML)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/emed-appts/emed-mailer/internal/config"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// logWriter forwards log output to a replaceable writer
+// so the logger can be reconfigured while other goroutines are logging
+type logWriter struct {
+	mu   sync.Mutex
+	out  io.Writer
+	file *os.File
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.out.Write(p)
+}
+
+// Close closes the current log file
+func (w *logWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	return w.file.Close()
+}
+
+// configure (re)opens the log file and applies the log settings of `cfg`
+func (w *logWriter) configure(cfg *config.Snapshot) error {
+	// open logfile
+	logFile, err := os.OpenFile(path.Join(cfg.General.Root, "emed-mailer.log"), os.O_CREATE|os.O_APPEND|os.O_RDWR, 0666)
+	if err != nil {
+		return errors.Wrap(err, "could not open log file")
+	}
+
+	// set configured log level
+	logLvl, err := zerolog.ParseLevel(cfg.Log.Level)
+	if err != nil {
+		logFile.Close()
+		return errors.Wrap(err, "could not parse log level")
+	}
+
+	var out io.Writer = logFile
+	if cfg.Log.Pretty {
+		out = zerolog.ConsoleWriter{
+			Out:     logFile,
+			NoColor: !cfg.Log.Colored,
+		}
+	}
+
+	w.mu.Lock()
+	previous := w.file
+	w.out, w.file = out, logFile
+	w.mu.Unlock()
+
+	if previous != nil {
+		previous.Close()
+	}
+	zerolog.SetGlobalLevel(logLvl)
+
+	return nil
+}
+
+// setupLogger directs the global logger to the log file of `cfg`
+func setupLogger(cfg *config.Snapshot) (*logWriter, error) {
+	w := &logWriter{}
+	if err := w.configure(cfg); err != nil {
+		return nil, err
+	}
+	log.Logger = log.Output(w)
+
+	return w, nil
+}
+
+// setupCommandLogger directs the global logger to the log file and the console,
+// so commands run by hand show what they are doing
+func setupCommandLogger(cfg *config.Snapshot) (*logWriter, error) {
+	w, err := setupLogger(cfg)
+	if err != nil {
+		return nil, err
+	}
+	log.Logger = log.Output(zerolog.MultiLevelWriter(w, zerolog.ConsoleWriter{Out: os.Stderr}))
+
+	return w, nil
+}
+
+// logSettings logs the effective settings of `cfg`
+func logSettings(cfg *config.Snapshot) {
+	for _, setting := range cfg.Settings {
+		log.Info().
+			Str("key", setting.Section+"."+setting.Key).
+			Str("value", setting.Value).
+			Str("source", setting.Source).
+			Msg("config")
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/emed-appts/emed-mailer/internal/notifier"
+	"github.com/emed-appts/emed-mailer/internal/state"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+var previewCommand = &cli.Command{
+	Name:  "preview",
+	Usage: "render the messages of a job without sending them",
+	Description: "Renders the messages a job would send now and prints them or writes them to --out.\n" +
+		"Nothing is sent and the last run of the job is kept.",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "job",
+			Usage: "name of the job, may be omitted if only one job is configured",
+		},
+		&cli.StringFlag{
+			Name:  "since",
+			Usage: "start of the period, e.g. \"2026-10-19 06:00\", defaults to the last run of the job",
+		},
+		&cli.StringFlag{
+			Name:  "until",
+			Usage: "end of the period, defaults to now",
+		},
+		&cli.StringFlag{
+			Name:  "format",
+			Value: notifier.FormatHTML,
+			Usage: "format of the messages, html or eml",
+		},
+		&cli.StringFlag{
+			Name:  "out",
+			Usage: "directory receiving a file per message, defaults to stdout",
+		},
+	},
+	Action: runPreview,
+}
+
+func runPreview(ctx *cli.Context) error {
+	format := ctx.String("format")
+	if err := checkFormat(format); err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+
+	cfg, logWriter, err := loadCommandConfig()
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	defer logWriter.Close()
+	loc := cfg.Location()
+
+	since, err := parseTimeFlag(ctx, "since", loc)
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	until, err := parseTimeFlag(ctx, "until", loc)
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+
+	name, err := selectJob(cfg, ctx.String("job"))
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	jc, _ := cfg.FindJob(name)
+
+	svc, err := startServices(cfg)
+	if err != nil {
+		return cli.Exit(errors.Cause(err).Error(), 1)
+	}
+	defer svc.Close()
+
+	if since.IsZero() {
+		since = initialLastRun(name, jc.Schedule, state.New(cfg.General.Root, name))
+	}
+	if until.IsZero() {
+		until = time.Now()
+	}
+	if !since.Before(until) {
+		return cli.Exit("--since must be before --until", 1)
+	}
+
+	svc.scheduler.dryRun = &dryRun{format: format, dir: ctx.String("out"), out: ctx.App.Writer}
+	j := svc.scheduler.newJob(cfg, name, since, nil)
+
+	count, err := j.RunWindow(since, until)
+	if err != nil {
+		return cli.Exit(fmt.Sprintf("job %s: %v", name, err), 1)
+	}
+	if out := ctx.String("out"); out != "" {
+		fmt.Fprintf(ctx.App.Writer, "job %s: rendered %d change(s) from %s until %s to %s\n", name, count, displayTime(since, loc), displayTime(until, loc), out)
+	} else if count == 0 {
+		fmt.Fprintf(ctx.App.ErrWriter, "job %s: no changes from %s until %s\n", name, displayTime(since, loc), displayTime(until, loc))
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// status of a diagnosis step of test-mail and check-db
+const (
+	statusOK   = "OK"
+	statusWarn = "WARN"
+	statusFail = "FAIL"
+)
+
+// printResult prints the result of a diagnosis step, the lines are aligned below the first one
+func printResult(w io.Writer, status, name string, lines ...string) {
+	if len(lines) == 0 {
+		lines = []string{""}
+	}
+
+	fmt.Fprintf(w, "%-4s  %-10s  %s\n", status, name, lines[0])
+	for _, line := range lines[1:] {
+		fmt.Fprintf(w, "%-4s  %-10s  %s\n", "", "", line)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/emed-appts/emed-mailer/internal/config"
+	"github.com/emed-appts/emed-mailer/internal/job"
+	"github.com/emed-appts/emed-mailer/internal/state"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+// layouts of times given by command line flags, interpreted in local time
+var timeLayouts = []string{
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+var runOnceCommand = &cli.Command{
+	Name:  "run-once",
+	Usage: "run a job immediately",
+	Description: "Runs a job once like the daemon would do now and advances its last run.\n" +
+		"With --since or --until the changes of that period are sent and the last run is kept.\n" +
+		"A running daemon does not notice the advanced last run, stop it before or use --since.",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "job",
+			Usage: "name of the job, may be omitted if only one job is configured",
+		},
+		&cli.StringFlag{
+			Name:  "since",
+			Usage: "start of the period, e.g. \"2026-10-19 06:00\", defaults to the last run of the job",
+		},
+		&cli.StringFlag{
+			Name:  "until",
+			Usage: "end of the period, defaults to now",
+		},
+	},
+	Action: runOnce,
+}
+
+func runOnce(ctx *cli.Context) error {
+	cfg, logWriter, err := loadCommandConfig()
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	defer logWriter.Close()
+	loc := cfg.Location()
+
+	since, err := parseTimeFlag(ctx, "since", loc)
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	until, err := parseTimeFlag(ctx, "until", loc)
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+
+	name, err := selectJob(cfg, ctx.String("job"))
+	if err != nil {
+		return cli.Exit(err.Error(), 1)
+	}
+	jc, _ := cfg.FindJob(name)
+
+	svc, err := startServices(cfg)
+	if err != nil {
+		return cli.Exit(errors.Cause(err).Error(), 1)
+	}
+	defer svc.Close()
+
+	lastRun := initialLastRun(name, jc.Schedule, state.New(cfg.General.Root, name))
+	j := svc.scheduler.newJob(cfg, name, lastRun, nil)
+
+	if since.IsZero() && until.IsZero() {
+		j.Run()
+
+		status := j.Status()
+		switch status.Result {
+		case job.ResultSuccess:
+			fmt.Fprintf(ctx.App.Writer, "job %s: reported %d change(s) since %s\n", name, status.Count, displayTime(lastRun, loc))
+			return nil
+		case job.ResultSkipped:
+			return cli.Exit(fmt.Sprintf("job %s: skipped, last run %s is within MIN_GAP", name, displayTime(lastRun, loc)), 1)
+		default:
+			return cli.Exit(fmt.Sprintf("job %s: %s", name, status.Error), 1)
+		}
+	}
+
+	if since.IsZero() {
+		since = lastRun
+	}
+	if until.IsZero() {
+		until = time.Now()
+	}
+	if !since.Before(until) {
+		return cli.Exit("--since must be before --until", 1)
+	}
+
+	count, err := j.RunWindow(since, until)
+	if err != nil {
+		return cli.Exit(fmt.Sprintf("job %s: %v", name, err), 1)
+	}
+	fmt.Fprintf(ctx.App.Writer, "job %s: reported %d change(s) from %s until %s\n", name, count, displayTime(since, loc), displayTime(until, loc))
+	return nil
+}
+
+// loadCommandConfig loads the configuration and sets up logging of commands
+func loadCommandConfig() (*config.Snapshot, *logWriter, error) {
+	if err := config.Load(); err != nil {
+		return nil, nil, errors.Errorf("%s: %v", config.Path, errors.Cause(err))
+	}
+	cfg := config.Current()
+
+	logWriter, err := setupCommandLogger(cfg)
+	if err != nil {
+		return nil, nil, errors.Errorf("could not set up logging: %v", errors.Cause(err))
+	}
+	return cfg, logWriter, nil
+}
+
+// selectJob checks that job `name` is configured in `cfg`
+// without name the only configured job is selected
+func selectJob(cfg *config.Snapshot, name string) (string, error) {
+	if name == "" {
+		if len(cfg.Jobs) != 1 {
+			return "", errors.Errorf("%d jobs are configured, select one by --job", len(cfg.Jobs))
+		}
+		return cfg.Jobs[0].Name, nil
+	}
+
+	if _, ok := cfg.FindJob(name); !ok {
+		return "", errors.Errorf("unknown job %q", name)
+	}
+	return name, nil
+}
+
+// displayTime formats `t` in the time zone `loc` of the messages
+func displayTime(t time.Time, loc *time.Location) string {
+	return t.In(loc).Format(timeLayouts[1])
+}
+
+// parseTimeFlag parses the time of flag `name` in the time zone `loc` of the messages, unset flags return the zero time
+func parseTimeFlag(ctx *cli.Context, name string, loc *time.Location) (time.Time, error) {
+	value := ctx.String(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.Errorf("invalid time %q of --%s, expected e.g. 2026-10-19 or \"2026-10-19 06:00\"", value, name)
+}
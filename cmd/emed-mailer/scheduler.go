@@ -0,0 +1,301 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/emed-appts/emed-mailer/internal/alert"
+	"github.com/emed-appts/emed-mailer/internal/collector"
+	"github.com/emed-appts/emed-mailer/internal/config"
+	"github.com/emed-appts/emed-mailer/internal/job"
+	"github.com/emed-appts/emed-mailer/internal/mailer"
+	"github.com/emed-appts/emed-mailer/internal/monitor"
+	"github.com/emed-appts/emed-mailer/internal/notifier"
+	"github.com/emed-appts/emed-mailer/internal/state"
+
+	"github.com/robfig/cron/v3"
+	"github.com/rs/zerolog/log"
+)
+
+// activationLookback limits the search of previous schedule activations
+const activationLookback = 31 * 24 * time.Hour
+
+// scheduledJob is a job registered at the scheduler
+type scheduledJob struct {
+	job      job.Job
+	entry    cron.EntryID
+	jobType  string
+	schedule string
+}
+
+// scheduler runs the configured jobs
+type scheduler struct {
+	cron      *cron.Cron
+	db        *collector.DB
+	collector job.Collector
+	mailer    *mailer.TextMailer
+	// writes the messages instead of sending them if set
+	dryRun *dryRun
+
+	// serializes apply
+	reload sync.Mutex
+	// guards jobs, the status is read by the http server
+	mu   sync.Mutex
+	jobs map[string]*scheduledJob
+}
+
+func newScheduler(db *collector.DB, collector job.Collector, m *mailer.TextMailer) *scheduler {
+	return &scheduler{
+		cron:      cron.New(),
+		db:        db,
+		collector: collector,
+		mailer:    m,
+		jobs:      map[string]*scheduledJob{},
+	}
+}
+
+// apply (re)creates the jobs of `cfg`
+// jobs known already continue at their last run and status,
+// so no appointment changes get lost and failures keep counting towards an alert
+func (s *scheduler) apply(cfg *config.Snapshot) {
+	s.reload.Lock()
+	defer s.reload.Unlock()
+
+	s.mu.Lock()
+	previousJobs := s.jobs
+	s.mu.Unlock()
+
+	// LastRun waits for a running job, so the jobs known already are read
+	// without holding mu to keep the status readable meanwhile
+	type carryOver struct {
+		lastRun time.Time
+		status  job.Status
+	}
+	carried := map[string]carryOver{}
+	for _, jc := range cfg.Jobs {
+		if previous, ok := previousJobs[jc.Name]; ok {
+			s.cron.Remove(previous.entry)
+			carried[jc.Name] = carryOver{
+				lastRun: previous.job.LastRun(),
+				status:  previous.job.Status(),
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := map[string]*scheduledJob{}
+
+	var alerter job.Alerter
+	if cfg.Alert.To != "" {
+		alerter = alert.New(alertMailerConfig(cfg))
+	}
+
+	for _, jc := range cfg.Jobs {
+		var lastRun time.Time
+		var status *job.Status
+		if previous, ok := carried[jc.Name]; ok {
+			lastRun = previous.lastRun
+			status = &previous.status
+		} else {
+			lastRun = initialLastRun(jc.Name, jc.Schedule, state.New(cfg.General.Root, jc.Name))
+		}
+
+		j := s.newJob(cfg, jc.Name, lastRun, alerter)
+		if status != nil {
+			j.Restore(*status)
+		}
+
+		jobs[jc.Name] = &scheduledJob{
+			job:      j,
+			entry:    s.cron.Schedule(jc.Schedule, cron.FuncJob(j.Run)),
+			jobType:  jc.Type,
+			schedule: jc.CronExpression,
+		}
+
+		log.Info().
+			Str("job", jc.Name).
+			Str("schedule", jc.CronExpression).
+			Time("lastRun", lastRun).
+			Msg("scheduled job")
+	}
+
+	// remove jobs not configured anymore
+	for name, previous := range s.jobs {
+		if _, ok := jobs[name]; !ok {
+			s.cron.Remove(previous.entry)
+
+			log.Info().
+				Str("job", name).
+				Msg("removed job")
+		}
+	}
+
+	s.jobs = jobs
+}
+
+// newJob creates the job `name` of `cfg` continuing at `lastRun`
+// `alerter` is told about failures, it is optional
+// on a dry run the messages are written, the watermark is kept and no alerts are sent
+func (s *scheduler) newJob(cfg *config.Snapshot, name string, lastRun time.Time, alerter job.Alerter) job.Job {
+	jc, _ := cfg.FindJob(name)
+
+	jobCfg := job.Config{
+		Name:      jc.Name,
+		Type:      job.Type(jc.Type),
+		MinGap:    jc.MinGap,
+		Watermark: state.New(cfg.General.Root, jc.Name),
+
+		Alerter:    alerter,
+		AlertAfter: cfg.Alert.After,
+
+		Filter:    job.Filter(jc.Filter),
+		Template:  jc.Template,
+		SendEmpty: jc.SendEmpty,
+
+		UpcomingDays:   jc.UpcomingDays,
+		ReminderBefore: jc.ReminderBefore,
+		Location:       cfg.Location(),
+	}
+	if jc.Enrich {
+		jobCfg.Directory = s.patientDirectory(cfg)
+	}
+
+	if s.dryRun != nil {
+		w := s.dryRun.writer(cfg, jc.Name, jc.To, jc.Subject)
+		jobCfg.Watermark = nil
+		jobCfg.Alerter = nil
+
+		if jobCfg.Type == job.TypePatients {
+			ledger := readOnlyLedger{state.NewLedger(cfg.General.Root, jc.Name)}
+			return job.NewPatientJob(s.collector, s.patientDirectory(cfg), w, ledger, lastRun, jobCfg)
+		}
+		return job.New(s.collector, w, lastRun, jobCfg)
+	}
+
+	if jobCfg.Type == job.TypePatients {
+		return job.NewPatientJob(s.collector, s.patientDirectory(cfg), deliverer{s.mailer}, state.NewLedger(cfg.General.Root, jc.Name), lastRun, jobCfg)
+	}
+	return job.New(s.collector, s.notifier(cfg, jc.Name, jc.ChannelNames(), jc.To, jc.Subject), lastRun, jobCfg)
+}
+
+// notifier creates the channels `names` of `cfg` for the job `jobName`
+// the built-in mail channel sends to `to` with `subject`, empty values fall back to [mail]
+func (s *scheduler) notifier(cfg *config.Snapshot, jobName string, names []string, to, subject string) job.Notifier {
+	var channels []notifier.Channel
+	for _, name := range names {
+		if name == config.MailChannel {
+			channels = append(channels, notifier.Channel{Name: name, Notifier: notifier.NewEmail(s.mailer, to, subject)})
+			continue
+		}
+
+		for _, c := range cfg.Channels {
+			if c.Name != name {
+				continue
+			}
+
+			var n job.Notifier
+			switch c.Type {
+			case config.ChannelEmail:
+				n = notifier.NewEmail(s.mailer, c.To, c.Subject)
+			case config.ChannelSMS:
+				n = notifier.NewSMS(notifier.SMSConfig{
+					URL:       c.URL,
+					Token:     c.Token,
+					To:        c.To,
+					ToField:   c.ToField,
+					TextField: c.TextField,
+					MaxLength: c.MaxLength,
+				})
+			case config.ChannelWebhook:
+				n = notifier.NewWebhook(c.URL, c.MaxLength)
+			case config.ChannelMatrix:
+				n = notifier.NewMatrix(c.URL, c.Token, c.Username, c.MaxLength)
+			}
+			channels = append(channels, notifier.Channel{Name: name, Notifier: n})
+		}
+	}
+
+	return notifier.NewMulti(notifier.NewOutbox(cfg.General.Root, jobName), channels...)
+}
+
+// deliverer waits until the smtp server accepted a mail,
+// so patient notifications are recorded in the ledger only once they are delivered
+type deliverer struct {
+	mailer *mailer.TextMailer
+}
+
+// SendMessageTo sends the message and returns the outcome of the delivery
+func (d deliverer) SendMessageTo(to, subject, contentType, messageText string) error {
+	return d.mailer.DeliverMessageTo(to, subject, contentType, messageText)
+}
+
+// patientDirectory creates the lookup of patients of `cfg`
+func (s *scheduler) patientDirectory(cfg *config.Snapshot) job.PatientDirectory {
+	return collector.NewPatientDirectory(s.db, patientConfig(cfg))
+}
+
+// status lists the status of all scheduled jobs
+func (s *scheduler) status() []monitor.JobStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statuses := make([]monitor.JobStatus, 0, len(s.jobs))
+	for name, scheduled := range s.jobs {
+		status := scheduled.job.Status()
+
+		js := monitor.JobStatus{
+			Name:     name,
+			Type:     scheduled.jobType,
+			Schedule: scheduled.schedule,
+			NextRun:  s.cron.Entry(scheduled.entry).Next,
+			LastRun:  status.LastRun,
+			Result:   string(status.Result),
+			Count:    status.Count,
+			Error:    status.Error,
+			Failures: status.Failures,
+		}
+		if !status.LastAttempt.IsZero() {
+			js.LastAttempt = &status.LastAttempt
+		}
+		statuses = append(statuses, js)
+	}
+	return statuses
+}
+
+// Start starts the scheduler
+func (s *scheduler) Start() {
+	s.cron.Start()
+}
+
+// Stop stops the scheduler and waits for running jobs to finish
+func (s *scheduler) Stop() {
+	<-s.cron.Stop().Done()
+}
+
+// initialLastRun continues at the last successful run of the job,
+// or at the previous activation of the schedule on first start
+func initialLastRun(name string, schedule cron.Schedule, watermark *state.Watermark) time.Time {
+	lastRun, err := watermark.Load()
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("job", name).
+			Msg("could not load watermark")
+	}
+	if lastRun.IsZero() {
+		lastRun = previousActivation(schedule, time.Now())
+	}
+	return lastRun
+}
+
+// previousActivation returns the latest activation of `schedule` before `now`
+// schedules without activation within the lookback start at the beginning of it
+func previousActivation(schedule cron.Schedule, now time.Time) time.Time {
+	prev := now.Add(-activationLookback)
+	for t := schedule.Next(prev); !t.IsZero() && t.Before(now); t = schedule.Next(t) {
+		prev = t
+	}
+	return prev
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/emed-appts/emed-mailer/internal/secret"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/term"
+)
+
+var secretCommand = &cli.Command{
+	Name:  "secret",
+	Usage: "manage secrets stored in the OS keyring",
+	Description: "Stores passwords in the platform keyring (Windows Credential Manager, macOS Keychain, Secret Service).\n" +
+		"Reference them in the config file with e.g. PASSWORD = keyring:emed-mailer/db",
+	Subcommands: []*cli.Command{
+		{
+			Name:      "set",
+			Usage:     "store a secret, read from stdin",
+			ArgsUsage: "SERVICE/USER",
+			Action: func(ctx *cli.Context) error {
+				service, user, err := secret.ParseKeyringRef(ctx.Args().First())
+				if err != nil {
+					return cli.Exit(err.Error(), 1)
+				}
+
+				value, err := readSecret(ctx)
+				if err != nil {
+					return cli.Exit(errors.Wrap(err, "could not read secret").Error(), 1)
+				}
+				if value == "" {
+					return cli.Exit("secret must not be empty", 1)
+				}
+
+				if err := secret.Store(service, user, value); err != nil {
+					return cli.Exit(err.Error(), 1)
+				}
+
+				fmt.Fprintf(ctx.App.Writer, "Stored secret. Reference it in the config file with:\nPASSWORD = keyring:%s/%s\n", service, user)
+				return nil
+			},
+		},
+		{
+			Name:      "delete",
+			Usage:     "remove a secret",
+			ArgsUsage: "SERVICE/USER",
+			Action: func(ctx *cli.Context) error {
+				service, user, err := secret.ParseKeyringRef(ctx.Args().First())
+				if err != nil {
+					return cli.Exit(err.Error(), 1)
+				}
+
+				if err := secret.Delete(service, user); err != nil {
+					return cli.Exit(err.Error(), 1)
+				}
+				return nil
+			},
+		},
+	},
+}
+
+// readSecret prompts for a secret without echo on terminals
+// or reads the first line of stdin otherwise
+func readSecret(ctx *cli.Context) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		fmt.Fprint(ctx.App.Writer, "Secret: ")
+		value, err := term.ReadPassword(fd)
+		fmt.Fprintln(ctx.App.Writer)
+		return string(value), err
+	}
+
+	value, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && value == "" {
+		return "", err
+	}
+	return strings.TrimRight(value, "\r\n"), nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// shutdownTimeout limits the time to finish open requests on shutdown
+const shutdownTimeout = 5 * time.Second
+
+// statusServer runs an embedded http server, the one of the status and health endpoints or the webhook
+type statusServer struct {
+	handler http.Handler
+	server  *http.Server
+}
+
+func newStatusServer(handler http.Handler) *statusServer {
+	return &statusServer{handler: handler}
+}
+
+// apply (re)starts the server listening on `listen`, an empty address stops it
+// the address is bound before returning, if that fails the server stays stopped
+// and the next call with the same address tries again
+func (s *statusServer) apply(listen string) error {
+	if s.server != nil && s.server.Addr == listen {
+		return nil
+	}
+	s.Stop()
+
+	if listen == "" {
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return errors.Wrapf(err, "could not listen on %s", listen)
+	}
+
+	server := &http.Server{
+		Addr:              listen,
+		Handler:           s.handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	s.server = server
+
+	log.Info().
+		Str("listen", listen).
+		Msg("starting http server")
+
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Error().
+				Err(err).
+				Str("listen", listen).
+				Msg("http server failed")
+		}
+	}()
+	return nil
+}
+
+// Stop shuts the server down
+func (s *statusServer) Stop() {
+	if s.server == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := s.server.Shutdown(ctx); err != nil {
+		log.Error().
+			Err(err).
+			Msg("could not shut down http server")
+	}
+	s.server = nil
+}
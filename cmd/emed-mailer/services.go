@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/emed-appts/emed-mailer/internal/collector"
+	"github.com/emed-appts/emed-mailer/internal/config"
+	"github.com/emed-appts/emed-mailer/internal/job"
+	"github.com/emed-appts/emed-mailer/internal/mailer"
+	"github.com/emed-appts/emed-mailer/internal/template"
+
+	"github.com/pkg/errors"
+)
+
+// services are shared by the daemon and the commands running jobs,
+// so jobs use the same collector, templates and mailer everywhere
+type services struct {
+	// db is nil if the exported logs or the events of the webhook are read
+	db *collector.DB
+	// webhook is set if the events of the webhook are read
+	webhook *collector.Webhook
+	// exported are the exported logs read if neither db nor webhook is set
+	exported  string
+	mailer    *mailer.TextMailer
+	scheduler *scheduler
+
+	// stops the mailer daemon
+	stop chan struct{}
+}
+
+// startServices connects to the database and starts the mailer of `cfg`
+func startServices(cfg *config.Snapshot) (*services, error) {
+	db, c, err := openCollector(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	stop := make(chan struct{}, 1)
+
+	// instantiate emed-mailer
+	m := mailer.New(mailerConfig(cfg))
+	// run emed-mailer daemon
+	if err := m.Run(stop); err != nil {
+		if db != nil {
+			db.Close()
+		}
+		return nil, errors.Wrap(err, "could not run mailer daemon")
+	}
+
+	template.SetDir(cfg.General.Templates)
+	template.SetLocation(cfg.Location())
+
+	webhook, _ := c.(*collector.Webhook)
+
+	return &services{
+		db:        db,
+		webhook:   webhook,
+		exported:  cfg.DB.File,
+		mailer:    m,
+		scheduler: newScheduler(db, c, m),
+		stop:      stop,
+	}, nil
+}
+
+// Close stops the mailer once it delivered the queued messages and closes the database
+func (svc *services) Close() {
+	close(svc.stop)
+	<-svc.mailer.Done()
+
+	if svc.db != nil {
+		svc.db.Close()
+	}
+}
+
+// checkDB checks the connection to the database, that the exported logs are readable
+// or that the events of the webhook can be stored
+func (svc *services) checkDB(ctx context.Context) error {
+	if svc.webhook != nil {
+		return svc.webhook.Check()
+	}
+	if svc.db == nil {
+		_, err := os.Stat(svc.exported)
+		return err
+	}
+	return svc.db.PingContext(ctx)
+}
+
+// openCollector connects to the database of `cfg` and creates the collector,
+// exported logs and the events of the webhook are read without database
+func openCollector(cfg *config.Snapshot) (*collector.DB, job.Collector, error) {
+	switch cfg.DB.Type {
+	case config.DBTypeCSV:
+		return nil, collector.NewCSV(cfg.DB.File, parseConfig(cfg)), nil
+	case config.DBTypeWebhook:
+		return nil, collector.NewWebhook(webhookConfig(cfg), parseConfig(cfg)), nil
+	}
+
+	db, err := collector.OpenSQL(dbConfig(cfg))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "could not connect to db")
+	}
+	return db, collector.New(db, parseConfig(cfg)), nil
+}
+
+// webhookConfig returns the endpoint receiving events of `cfg`
+// the events are stored below [general] ROOT
+func webhookConfig(cfg *config.Snapshot) collector.WebhookConfig {
+	return collector.WebhookConfig{
+		Secret:    cfg.Webhook.Secret,
+		Tolerance: cfg.Webhook.Tolerance,
+		Retention: cfg.Webhook.Retention,
+		File:      filepath.Join(cfg.General.Root, "webhook.events.jsonl"),
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/emed-appts/emed-mailer/internal/config"
+	"github.com/emed-appts/emed-mailer/internal/preview"
+	"github.com/emed-appts/emed-mailer/internal/template"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+var templateCommand = &cli.Command{
+	Name:  "template",
+	Usage: "work on the message templates",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "serve",
+			Usage: "preview the templates with fixture data in the browser",
+			Description: "Serves every template rendered against the JSON and YAML fixtures in --fixtures.\n" +
+				"Templates and fixtures are read on every request and open pages reload on changes,\n" +
+				"template errors are shown with the failing line. No database is needed.",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "listen",
+					Value: "127.0.0.1:8025",
+					Usage: "address of the preview server",
+				},
+				&cli.StringFlag{
+					Name:  "dir",
+					Usage: "directory of custom templates, defaults to TEMPLATES of the configuration",
+				},
+				&cli.StringFlag{
+					Name:  "fixtures",
+					Value: "conf/fixtures",
+					Usage: "directory of the fixture files",
+				},
+			},
+			Action: serveTemplates,
+		},
+	},
+}
+
+func serveTemplates(ctx *cli.Context) error {
+	dir := ctx.String("dir")
+	if dir == "" {
+		if err := config.Load(); err != nil {
+			fmt.Fprintf(ctx.App.ErrWriter, "%s: %v\nshowing the embedded templates only\n", config.Path, errors.Cause(err))
+		} else {
+			cfg := config.Current()
+			dir = cfg.General.Templates
+			template.SetLocation(cfg.Location())
+		}
+	}
+	template.SetDir(dir)
+
+	fixtures := ctx.String("fixtures")
+	if !filepath.IsAbs(fixtures) && config.AppWorkPath != "" {
+		fixtures = filepath.Join(config.AppWorkPath, fixtures)
+	}
+	if info, err := os.Stat(fixtures); err != nil || !info.IsDir() {
+		return cli.Exit(fmt.Sprintf("fixture directory %s does not exist", fixtures), 1)
+	}
+
+	server := &http.Server{
+		Addr:              ctx.String("listen"),
+		Handler:           preview.New(fixtures),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+	fmt.Fprintf(ctx.App.Writer, "serving templates at http://%s, press Ctrl+C to stop\n", server.Addr)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	select {
+	case err := <-errs:
+		return cli.Exit(err.Error(), 1)
+	case <-sigs:
+		return server.Close()
+	}
+}
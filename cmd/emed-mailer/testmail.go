@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/emed-appts/emed-mailer/internal/config"
+	"github.com/emed-appts/emed-mailer/internal/mailer"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+var testMailCommand = &cli.Command{
+	Name:  "test-mail",
+	Usage: "send a test mail and diagnose the smtp connection",
+	Description: "Connects to the smtp server of [mail] like the daemon, reports every step of the conversation\n" +
+		"(connection, EHLO capabilities, TLS, authentication, sender, recipients) and sends a test message.",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "to",
+			Usage: "recipients of the test mail, defaults to TO of the configuration",
+		},
+		&cli.BoolFlag{
+			Name:  "alert",
+			Usage: "test the settings of [alert] instead of [mail]",
+		},
+	},
+	Action: testMail,
+}
+
+func testMail(ctx *cli.Context) error {
+	if err := config.Load(); err != nil {
+		return cli.Exit(fmt.Sprintf("%s: %v", config.Path, errors.Cause(err)), 1)
+	}
+
+	snapshot := config.Current()
+	cfg := mailerConfig(snapshot)
+	if ctx.Bool("alert") {
+		if snapshot.Alert.To == "" {
+			return cli.Exit("alerts are disabled, [alert] has no TO", 1)
+		}
+		cfg = alertMailerConfig(snapshot)
+	}
+	if to := ctx.String("to"); to != "" {
+		cfg.To = to
+	}
+
+	w := ctx.App.Writer
+	fmt.Fprintf(w, "testing %s:%d, from %s to %s\n\n", cfg.Server, cfg.Port, cfg.From, cfg.To)
+
+	host, _ := os.Hostname()
+	text := fmt.Sprintf("Diese Nachricht wurde von emed-mailer auf %s am %s zum Test der Mailkonfiguration gesendet.",
+		host, time.Now().Format("02.01.2006 15:04"))
+
+	var failed string
+	err := mailer.Diagnose(cfg, "emed-mailer Testnachricht", text, func(s *mailer.Step) {
+		printStep(w, s)
+		if s.Err != nil {
+			failed = s.Name
+		}
+	})
+	if err != nil {
+		return cli.Exit(fmt.Sprintf("\ntest mail failed at step %s", failed), 1)
+	}
+
+	fmt.Fprintf(w, "\ntest mail sent to %s\n", cfg.To)
+	return nil
+}
+
+// printStep prints the outcome of a diagnosis step
+func printStep(w io.Writer, s *mailer.Step) {
+	status := statusOK
+	switch {
+	case s.Err != nil:
+		status = statusFail
+	case s.Hint != "":
+		status = statusWarn
+	}
+
+	lines := s.Details
+	if s.Err != nil {
+		lines = append(lines, "error: "+s.Err.Error())
+	}
+	if s.Hint != "" {
+		lines = append(lines, "hint: "+s.Hint)
+	}
+	printResult(w, status, s.Name, lines...)
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/emed-appts/emed-mailer/internal/config"
+
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+var validateConfigCommand = &cli.Command{
+	Name:  "validate-config",
+	Usage: "check the configuration file and report all problems",
+	Action: func(ctx *cli.Context) error {
+		err := config.Load()
+		if err != nil {
+			fmt.Fprintf(ctx.App.Writer, "%s: %v\n", config.Path, errors.Cause(err))
+			return cli.Exit("", 1)
+		}
+
+		fmt.Fprintf(ctx.App.Writer, "%s: configuration is valid\n", config.Path)
+		return nil
+	},
+}
@@ -0,0 +1,41 @@
+package alert
+
+import (
+	"github.com/emed-appts/emed-mailer/internal/job"
+	"github.com/emed-appts/emed-mailer/internal/mailer"
+	"github.com/emed-appts/emed-mailer/internal/template"
+)
+
+// templates of the alerts
+const (
+	FailedTemplate    = "alert_failed.tmpl"
+	RecoveredTemplate = "alert_recovered.tmpl"
+)
+
+type mailAlerter struct {
+	cfg mailer.Config
+}
+
+// New returns an Alerter mailing alerts to the recipients of `cfg`
+// alerts are sent directly, so they also arrive if the mailer daemon is stuck
+func New(cfg mailer.Config) job.Alerter {
+	return &mailAlerter{cfg}
+}
+
+// Failed mails that a job keeps failing
+func (a *mailAlerter) Failed(alert *job.Alert) error {
+	return a.send(FailedTemplate, alert)
+}
+
+// Recovered mails that a job works again
+func (a *mailAlerter) Recovered(alert *job.Alert) error {
+	return a.send(RecoveredTemplate, alert)
+}
+
+func (a *mailAlerter) send(tmpl string, alert *job.Alert) error {
+	rendered, err := template.Render(tmpl, alert)
+	if err != nil {
+		return err
+	}
+	return mailer.Send(a.cfg, rendered.Subject, "text/html", rendered.Body)
+}
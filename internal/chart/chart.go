@@ -0,0 +1,92 @@
+package chart
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"html/template"
+	"math"
+)
+
+// chart dimensions in pixels
+const (
+	width        = 640
+	height       = 220
+	marginLeft   = 32
+	marginRight  = 8
+	marginTop    = 24
+	marginBottom = 36
+)
+
+// Series is a named row of values drawn in one color
+type Series struct {
+	Name   string
+	Color  string
+	Values []float64
+}
+
+// Bars renders a grouped bar chart as inline SVG
+// every label gets one bar of each series
+func Bars(labels []string, series ...Series) template.HTML {
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="Arial, Helvetica, sans-serif" font-size="10">`, width, height, width, height)
+
+	max := 0.0
+	for _, s := range series {
+		for _, v := range s.Values {
+			max = math.Max(max, v)
+		}
+	}
+	if max == 0 {
+		max = 1
+	}
+
+	plotWidth := float64(width - marginLeft - marginRight)
+	plotHeight := float64(height - marginTop - marginBottom)
+	bottom := float64(height - marginBottom)
+
+	// axis and maximum
+	fmt.Fprintf(buf, `<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="#888"/>`, marginLeft, bottom, width-marginRight, bottom)
+	fmt.Fprintf(buf, `<text x="%d" y="%d" text-anchor="end">%s</text>`, marginLeft-4, marginTop+4, formatValue(max))
+	fmt.Fprintf(buf, `<text x="%d" y="%.1f" text-anchor="end">0</text>`, marginLeft-4, bottom)
+
+	if len(labels) > 0 && len(series) > 0 {
+		group := plotWidth / float64(len(labels))
+		bar := group * 0.8 / float64(len(series))
+		// show at most ~16 labels to keep them readable
+		every := (len(labels) + 15) / 16
+
+		for i, label := range labels {
+			x := float64(marginLeft) + group*float64(i) + group*0.1
+			for j, s := range series {
+				if i >= len(s.Values) {
+					continue
+				}
+				h := s.Values[i] / max * plotHeight
+				fmt.Fprintf(buf, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"><title>%s %s: %s</title></rect>`,
+					x+bar*float64(j), bottom-h, bar, h, html.EscapeString(s.Color),
+					html.EscapeString(label), html.EscapeString(s.Name), formatValue(s.Values[i]))
+			}
+			if i%every == 0 {
+				fmt.Fprintf(buf, `<text x="%.1f" y="%.1f" text-anchor="middle">%s</text>`, x+group*0.4, bottom+14, html.EscapeString(label))
+			}
+		}
+	}
+
+	// legend
+	for j, s := range series {
+		x := marginLeft + j*120
+		fmt.Fprintf(buf, `<rect x="%d" y="%d" width="10" height="10" fill="%s"/>`, x, height-14, html.EscapeString(s.Color))
+		fmt.Fprintf(buf, `<text x="%d" y="%d">%s</text>`, x+14, height-5, html.EscapeString(s.Name))
+	}
+
+	buf.WriteString(`</svg>`)
+	return template.HTML(buf.String())
+}
+
+func formatValue(v float64) string {
+	if v == math.Trunc(v) {
+		return fmt.Sprintf("%.0f", v)
+	}
+	return fmt.Sprintf("%.1f", v)
+}
@@ -0,0 +1,7 @@
+//go:build !windows
+// +build !windows
+
+package collector
+
+// integrated authentication uses SSPI which is only available on windows
+const integratedAuthSupported = false
@@ -0,0 +1,4 @@
+package collector
+
+// integrated authentication uses SSPI which is only available on windows
+const integratedAuthSupported = true
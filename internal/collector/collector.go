@@ -1,16 +1,37 @@
 package collector
 
 import (
-	"database/sql"
+	"sort"
 	"strings"
 	"time"
 
-	"github.com/emed-appts/emed-mailer/internal/collector/tzinfo"
 	"github.com/emed-appts/emed-mailer/internal/job"
+	"github.com/emed-appts/emed-mailer/internal/metrics"
 
 	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
 )
 
+// logged actions
+const (
+	actionBooking      = "eFill"
+	actionCancellation = "eDel"
+)
+
+// DefaultTimeFormats are the layouts of the time of an appointment if none are configured
+// single digits are accepted, e.g. 9:5 is 09:05
+var DefaultTimeFormats = []string{"15:4", "15.4", "15:4:5", "1504"}
+
+// ParseConfig struct encapsulate the interpretation of the appointment log
+type ParseConfig struct {
+	// TimeFormats are the layouts of the time of an appointment, tried in order
+	TimeFormats []string
+	// TxtRules extract structured fields from txt, the first matching rule is applied
+	TxtRules []*TxtRule
+	// Location is the time zone of the wall clock times stored in the database, defaults to the local time zone
+	Location *time.Location
+}
+
 type logEntry struct {
 	logTime time.Time
 	action  string
@@ -18,76 +39,216 @@ type logEntry struct {
 	time    string
 	pid     int
 	txt     string
+
+	// invalid describes missing values, the entry can not be interpreted then
+	invalid string
 }
 
-type dbCollector struct {
-	db *sql.DB
+// source loads entries of the appointment log
+// times are wall clock times of the database as UTC, like the ones of the returned entries
+type source interface {
+	// loads the entries logged after `since` ordered by datlog
+	changed(since time.Time) ([]*logEntry, error)
+	// loads the entries of appointments on days from `from` until before `to` ordered by datlog
+	upcoming(from, to time.Time) ([]*logEntry, error)
 }
 
-// New creates a collector instance
-func New(db *sql.DB) job.Collector {
-	return &dbCollector{db}
+type logCollector struct {
+	source source
+	cfg    ParseConfig
 }
 
-// CollectChangedAppts gathers changed appointments since `lastRun`
-func (collector *dbCollector) CollectChangedAppts(lastRun time.Time) ([]*job.ApptChange, error) {
-	// fetch all changed appointments since `lastRun`
-	stmt, err := collector.db.Prepare("SELECT datlog, action, datum, zeit, pid, txt FROM pds7_kallog WHERE usc = 'eT' AND datlog > @p1 ORDER BY datlog ASC")
-	if err != nil {
-		return nil, errors.Wrap(err, "could not prepare the database query")
+// New creates a collector instance reading the appointment log of the database
+// log entries which can not be interpreted are reported as ApptChange with Error
+func New(db *DB, cfg ParseConfig) job.Collector {
+	return newLogCollector(&sqlSource{db}, cfg)
+}
+
+func newLogCollector(source source, cfg ParseConfig) *logCollector {
+	if len(cfg.TimeFormats) == 0 {
+		cfg.TimeFormats = DefaultTimeFormats
 	}
-	defer stmt.Close()
+	if cfg.Location == nil {
+		cfg.Location = time.Local
+	}
+	return &logCollector{source, cfg}
+}
 
-	rows, err := stmt.Query(lastRun)
+// CollectChangedAppts gathers changed appointments since `lastRun`
+func (collector *logCollector) CollectChangedAppts(lastRun time.Time) ([]*job.ApptChange, error) {
+	// fetch all changed appointments since `lastRun`
+	entries, err := collector.source.changed(collector.toDB(lastRun))
 	if err != nil {
-		return nil, errors.Wrap(err, "could not execute the database query")
+		return nil, err
 	}
-	defer rows.Close()
+	collector.localize(entries)
 
 	var changedAppts []*job.ApptChange
-	for rows.Next() {
-		entry := &logEntry{}
-		err := rows.Scan(&entry.logTime, &entry.action, &entry.date, &entry.time, &entry.pid, &entry.txt)
+	for _, entry := range entries {
+		change := &job.ApptChange{
+			Time:        entry.logTime,
+			PatientID:   entry.pid,
+			PatientName: entry.patientName(),
+			IsBooking:   entry.action == actionBooking,
+		}
+		if fields := parseTxt(entry.txt, collector.cfg.TxtRules); fields != nil {
+			change.Surname = fields.surname
+			change.FirstName = fields.firstName
+			change.BirthDate = fields.birthDate
+			change.Phone = fields.phone
+			change.Comment = fields.comment
+		}
+		changedAppts = append(changedAppts, change)
+
+		appointment, err := collector.appointment(entry)
 		if err != nil {
-			return nil, errors.Wrap(err, "could not scan database row")
+			change.Error = err.Error()
+			quarantine(entry, err)
+			metrics.ChangesCollected.WithLabelValues("unparsed").Inc()
+			continue
 		}
+		change.Appointment = appointment
+
+		if change.IsBooking {
+			metrics.ChangesCollected.WithLabelValues("booking").Inc()
+		} else {
+			metrics.ChangesCollected.WithLabelValues("cancellation").Inc()
+		}
+	}
+
+	return changedAppts, nil
+}
+
+// CollectUpcomingAppts gathers the booked appointments from `from` until `to`
+// an appointment is booked if its latest log entry is a booking
+func (collector *logCollector) CollectUpcomingAppts(from, to time.Time) ([]*job.UpcomingAppt, error) {
+	entries, err := collector.source.upcoming(collector.toDB(truncateDay(from.In(collector.cfg.Location))), collector.toDB(to))
+	if err != nil {
+		return nil, err
+	}
+	collector.localize(entries)
+
+	type slot struct {
+		date string
+		time string
+		pid  int
+	}
+	latest := map[slot]*logEntry{}
+	for _, entry := range entries {
+		latest[slot{entry.date.Format("2006-01-02"), entry.time, entry.pid}] = entry
+	}
 
-		// txt contains <name>, <anything>
-		name := strings.SplitN(entry.txt, ",", 2)[0]
+	var upcomingAppts []*job.UpcomingAppt
+	for _, entry := range latest {
+		if entry.action != actionBooking {
+			continue
+		}
 
-		// string -> time.Time
-		t, err := parseTime(entry.time)
+		// the digest shows the entry as changed appointment already
+		appointment, err := collector.appointment(entry)
 		if err != nil {
-			return nil, errors.WithStack(err)
+			quarantine(entry, err)
+			continue
+		}
+		if appointment.Before(from) || !appointment.Before(to) {
+			continue
 		}
 
-		changedAppts = append(changedAppts, &job.ApptChange{
-			Time:        entry.logTime,
-			Appointment: entry.date.Add(timeDuration(t)),
+		upcomingAppts = append(upcomingAppts, &job.UpcomingAppt{
+			Appointment: appointment,
+			BookedAt:    entry.logTime,
 			PatientID:   entry.pid,
-			PatientName: name,
-			IsBooking:   entry.action == "eFill",
+			PatientName: entry.patientName(),
 		})
 	}
-	err = rows.Err()
+
+	sort.Slice(upcomingAppts, func(i, j int) bool {
+		return upcomingAppts[i].Appointment.Before(upcomingAppts[j].Appointment)
+	})
+
+	return upcomingAppts, nil
+}
+
+// patientName extracts the name of the patient
+func (entry *logEntry) patientName() string {
+	// txt contains <name>, <anything>
+	return strings.SplitN(entry.txt, ",", 2)[0]
+}
+
+// appointment returns the time of the appointment of `entry`
+func (collector *logCollector) appointment(entry *logEntry) (time.Time, error) {
+	if entry.invalid != "" {
+		return time.Time{}, errors.New(entry.invalid)
+	}
+
+	clock, err := parseTime(entry.time, collector.cfg.TimeFormats)
 	if err != nil {
-		return nil, errors.Wrap(err, "row got an error")
+		return time.Time{}, err
 	}
 
-	return changedAppts, nil
+	// the wall clock time is set on the day, adding a duration to midnight is off on days of DST changes
+	year, month, day := entry.date.Date()
+	return time.Date(year, month, day, clock.Hour(), clock.Minute(), 0, 0, collector.cfg.Location), nil
 }
 
-// parseTime parses Time expected to be in Timezone Europe/Vienna
-func parseTime(value string) (time.Time, error) {
-	loc, err := tzinfo.LoadLocation("Europe/Vienna")
-	if err != nil {
-		return time.Time{}, errors.Wrap(err, "could not load location \"Europe/Vienna\"")
+// localize sets the time zone of the database on the wall clock times of `entries`
+func (collector *logCollector) localize(entries []*logEntry) {
+	for _, entry := range entries {
+		entry.logTime = collector.fromDB(entry.logTime)
+		entry.date = collector.fromDB(entry.date)
+	}
+}
+
+// fromDB interprets the wall clock time of a datetime column in the time zone of the database
+// the driver returns values of columns without time zone as UTC
+// wall clock times within the hour repeated at the end of DST are ambiguous, time.Date picks one of them
+func (collector *logCollector) fromDB(t time.Time) time.Time {
+	year, month, day := t.Date()
+	hour, min, sec := t.Clock()
+	return time.Date(year, month, day, hour, min, sec, t.Nanosecond(), collector.cfg.Location)
+}
+
+// toDB returns the wall clock time of `t` in the time zone of the database as UTC
+// sqlserver compares datetime columns with parameters as if the columns were UTC
+func (collector *logCollector) toDB(t time.Time) time.Time {
+	return wallClock(t, collector.cfg.Location)
+}
+
+// wallClock returns the wall clock time of `t` in `loc` as UTC
+func wallClock(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	year, month, day := t.Date()
+	hour, min, sec := t.Clock()
+	return time.Date(year, month, day, hour, min, sec, t.Nanosecond(), time.UTC)
+}
+
+// quarantine logs an entry which can not be interpreted
+func quarantine(entry *logEntry, err error) {
+	log.Warn().
+		Err(err).
+		Time("datlog", entry.logTime).
+		Int("pid", entry.pid).
+		Msg("could not interpret log entry, quarantined")
+}
+
+// parseTime parses the wall clock time of an appointment, only hour and minute are meaningful
+// the layouts `formats` are tried in order
+func parseTime(value string, formats []string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, errors.New("zeit is empty")
+	}
+
+	for _, format := range formats {
+		if t, err := time.Parse(format, value); err == nil {
+			return t, nil
+		}
 	}
-	t, err := time.ParseInLocation("15:04", value, loc)
 
-	return t, errors.Wrap(err, "could not parse time")
+	return time.Time{}, errors.Errorf("zeit %q matches none of the formats %s", value, strings.Join(formats, ", "))
 }
 
-func timeDuration(t time.Time) time.Duration {
-	return time.Hour*time.Duration(t.Hour()) + time.Minute*time.Duration(t.Minute())
+// truncateDay returns midnight of the day of `t`
+func truncateDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
 }
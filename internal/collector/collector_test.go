@@ -0,0 +1,104 @@
+package collector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/emed-appts/emed-mailer/internal/collector/tzinfo"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTime(t *testing.T) {
+	tests := []struct {
+		value string
+		hour  int
+		min   int
+		err   bool
+	}{
+		{value: "09:30", hour: 9, min: 30},
+		{value: "9:5", hour: 9, min: 5},
+		{value: "14.15", hour: 14, min: 15},
+		{value: "0815", hour: 8, min: 15},
+		{value: "18:00:00", hour: 18},
+		{value: "", err: true},
+		{value: "9:5x", err: true},
+		{value: "25:00", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			parsed, err := parseTime(tt.value, DefaultTimeFormats)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.hour, parsed.Hour())
+				assert.Equal(t, tt.min, parsed.Minute())
+			}
+		})
+	}
+}
+
+func TestAppointment_DST(t *testing.T) {
+	vienna, err := tzinfo.LoadLocation("Europe/Vienna")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &logCollector{cfg: ParseConfig{TimeFormats: DefaultTimeFormats, Location: vienna}}
+
+	tests := []struct {
+		name string
+		date time.Time
+		time string
+		want time.Time
+	}{
+		{name: "before march", date: time.Date(2026, 3, 28, 0, 0, 0, 0, time.UTC), time: "09:00", want: time.Date(2026, 3, 28, 8, 0, 0, 0, time.UTC)},
+		{name: "march", date: time.Date(2026, 3, 29, 0, 0, 0, 0, time.UTC), time: "09:00", want: time.Date(2026, 3, 29, 7, 0, 0, 0, time.UTC)},
+		{name: "march early", date: time.Date(2026, 3, 29, 0, 0, 0, 0, time.UTC), time: "01:30", want: time.Date(2026, 3, 29, 0, 30, 0, 0, time.UTC)},
+		{name: "before october", date: time.Date(2026, 10, 24, 0, 0, 0, 0, time.UTC), time: "09:00", want: time.Date(2026, 10, 24, 7, 0, 0, 0, time.UTC)},
+		{name: "october", date: time.Date(2026, 10, 25, 0, 0, 0, 0, time.UTC), time: "09:00", want: time.Date(2026, 10, 25, 8, 0, 0, 0, time.UTC)},
+		{name: "october late", date: time.Date(2026, 10, 25, 0, 0, 0, 0, time.UTC), time: "23:45", want: time.Date(2026, 10, 25, 22, 45, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := &logEntry{date: c.fromDB(tt.date), time: tt.time}
+			appointment, err := c.appointment(entry)
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.want, appointment.UTC())
+				assert.Equal(t, tt.time, appointment.Format("15:04"))
+			}
+		})
+	}
+}
+
+func TestFromDB_DST(t *testing.T) {
+	vienna, err := tzinfo.LoadLocation("Europe/Vienna")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &logCollector{cfg: ParseConfig{Location: vienna}}
+
+	// datlog holds the wall clock time of the practice, the driver returns it as UTC
+	tests := []struct {
+		name   string
+		datlog time.Time
+		want   time.Time
+	}{
+		{name: "winter", datlog: time.Date(2026, 3, 29, 1, 59, 0, 0, time.UTC), want: time.Date(2026, 3, 29, 0, 59, 0, 0, time.UTC)},
+		{name: "march", datlog: time.Date(2026, 3, 29, 3, 15, 0, 0, time.UTC), want: time.Date(2026, 3, 29, 1, 15, 0, 0, time.UTC)},
+		{name: "summer", datlog: time.Date(2026, 10, 25, 1, 59, 0, 0, time.UTC), want: time.Date(2026, 10, 24, 23, 59, 0, 0, time.UTC)},
+		{name: "october", datlog: time.Date(2026, 10, 25, 3, 15, 0, 0, time.UTC), want: time.Date(2026, 10, 25, 2, 15, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logTime := c.fromDB(tt.datlog)
+			assert.Equal(t, tt.want, logTime.UTC())
+			// parameters are passed as wall clock time again
+			assert.Equal(t, tt.datlog, c.toDB(logTime))
+		})
+	}
+}
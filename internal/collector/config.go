@@ -1,10 +1,47 @@
 package collector
 
-// DBConfig struct encapsulate all settings for dbCollector
+import (
+	"github.com/emed-appts/emed-mailer/internal/config"
+)
+
+// authentication modes supported by OpenSQL for sqlserver
+const (
+	// AuthSQL uses a SQL Server login
+	AuthSQL = "sql"
+	// AuthWindows uses integrated authentication of the service account (SSPI/Kerberos)
+	AuthWindows = "windows"
+	// AuthNTLM uses explicit domain credentials
+	AuthNTLM = "ntlm"
+)
+
+// DBConfig struct encapsulate the settings of the connection to the practice database
 type DBConfig struct {
-	Server   string
+	// Type selects driver and dialect, defaults to config.DBTypeMSSQL
+	Type string
+	// DSN overrides all other connection settings if set
+	DSN string
+	// File is the database file of config.DBTypeSQLite or the exported logs of config.DBTypeCSV
+	File string
+
+	Server string
+	// Instance is the named instance, resolved by SQL Browser if Port is not set
+	Instance string
 	Port     int
+
+	Auth     string
+	Domain   string
 	User     string
 	Password string
+	// SPN overrides the service principal name used for integrated authentication
+	SPN string
+
 	Database string
 }
+
+// dbType returns the database type, sqlserver if none is set
+func (cfg DBConfig) dbType() string {
+	if cfg.Type == "" {
+		return config.DBTypeMSSQL
+	}
+	return cfg.Type
+}
@@ -0,0 +1,218 @@
+package collector
+
+import (
+	"encoding/csv"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emed-appts/emed-mailer/internal/job"
+	"github.com/emed-appts/emed-mailer/internal/metrics"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// layouts of datlog and datum in exported logs, tried in order
+var (
+	csvTimeLayouts = []string{
+		"2006-01-02 15:04:05", "2006-01-02 15:04:05.999999999", "2006-01-02T15:04:05", "2006-01-02 15:04",
+		"02.01.2006 15:04:05", "02.01.2006 15:04",
+	}
+	csvDateLayouts = append([]string{"2006-01-02", "02.01.2006"}, csvTimeLayouts...)
+)
+
+// csvColumns are the columns required in exported logs, usc is optional
+var csvColumns = []string{"datlog", "action", "datum", "zeit", "pid", "txt"}
+
+// csvSource reads the appointment log exported as CSV files
+type csvSource struct {
+	// path is a file or a directory whose *.csv files are read
+	path string
+}
+
+// NewCSV creates a collector instance reading the appointment log exported as CSV
+// `path` is a file or a directory whose *.csv files are read, on every collection so they can be replaced by newer exports
+// the first row names the columns like the ones of the log table, they are separated by ; , or tab
+func NewCSV(path string, cfg ParseConfig) job.Collector {
+	return newLogCollector(&csvSource{path}, cfg)
+}
+
+func (source *csvSource) changed(since time.Time) ([]*logEntry, error) {
+	timer := prometheus.NewTimer(metrics.QueryDuration.WithLabelValues("changed"))
+	defer timer.ObserveDuration()
+
+	return source.read(func(entry *logEntry) bool {
+		return entry.logTime.After(since)
+	})
+}
+
+func (source *csvSource) upcoming(from, to time.Time) ([]*logEntry, error) {
+	timer := prometheus.NewTimer(metrics.QueryDuration.WithLabelValues("upcoming"))
+	defer timer.ObserveDuration()
+
+	return source.read(func(entry *logEntry) bool {
+		return !entry.date.IsZero() && !entry.date.Before(from) && entry.date.Before(to)
+	})
+}
+
+// read loads the entries of all files selected by `match` ordered by datlog
+func (source *csvSource) read(match func(*logEntry) bool) ([]*logEntry, error) {
+	files, err := source.files()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*logEntry
+	for _, file := range files {
+		fileEntries, err := readCSV(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range fileEntries {
+			if match(entry) {
+				entries = append(entries, entry)
+			}
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].logTime.Before(entries[j].logTime)
+	})
+	return entries, nil
+}
+
+// files lists the exported logs in order of their names
+func (source *csvSource) files() ([]string, error) {
+	info, err := os.Stat(source.path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open the exported log")
+	}
+	if !info.IsDir() {
+		return []string{source.path}, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(source.path, "*.csv"))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list the exported logs")
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// readCSV reads the entries of the exported log `file`
+// rows without datlog are skipped, the database query would not return them either
+func readCSV(file string) ([]*logEntry, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read the exported log")
+	}
+	// spreadsheets prepend a byte order mark
+	text := strings.TrimPrefix(string(content), "\ufeff")
+
+	r := csv.NewReader(strings.NewReader(text))
+	r.Comma = detectDelimiter(text)
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+
+	header, err := r.Read()
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read the header of %s", file)
+	}
+	index := map[string]int{}
+	for i, name := range header {
+		index[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range csvColumns {
+		if _, ok := index[name]; !ok {
+			return nil, errors.Errorf("column %s missing in %s", name, file)
+		}
+	}
+	usc, hasUsc := index["usc"]
+
+	var entries []*logEntry
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not read %s", file)
+		}
+
+		field := func(i int) string {
+			if i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		if hasUsc && field(usc) != "eT" {
+			continue
+		}
+
+		line, _ := r.FieldPos(0)
+		logTime, ok := parseCSVTime(field(index["datlog"]), csvTimeLayouts)
+		if !ok {
+			log.Warn().
+				Str("file", file).
+				Int("line", line).
+				Str("datlog", field(index["datlog"])).
+				Msg("skipped exported log entry without valid datlog")
+			continue
+		}
+
+		entry := &logEntry{
+			logTime: logTime,
+			action:  field(index["action"]),
+			time:    field(index["zeit"]),
+			txt:     field(index["txt"]),
+		}
+
+		date, ok := parseCSVTime(field(index["datum"]), csvDateLayouts)
+		pid, pidErr := strconv.Atoi(field(index["pid"]))
+		switch {
+		case !ok:
+			entry.invalid = "datum is empty or not a date"
+		case pidErr != nil:
+			entry.invalid = "pid is empty or not a number"
+		}
+		entry.date = date
+		entry.pid = pid
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// detectDelimiter selects the delimiter occurring most often in the header row
+// spreadsheets with German settings export ; instead of ,
+func detectDelimiter(text string) rune {
+	header := text
+	if i := strings.IndexAny(text, "\r\n"); i != -1 {
+		header = text[:i]
+	}
+
+	delimiter, count := ';', strings.Count(header, ";")
+	for _, d := range []rune{',', '\t'} {
+		if c := strings.Count(header, string(d)); c > count {
+			delimiter, count = d, c
+		}
+	}
+	return delimiter
+}
+
+// parseCSVTime parses `value` as wall clock time by the first matching layout
+func parseCSVTime(value string, layouts []string) (time.Time, bool) {
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,63 @@
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/emed-appts/emed-mailer/internal/collector/tzinfo"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollector_CSV(t *testing.T) {
+	vienna, err := tzinfo.LoadLocation("Europe/Vienna")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	files := map[string]string{
+		// spreadsheet export with byte order mark, German layouts and the usc column
+		"2026-10.csv": "\ufeffdatlog;usc;action;datum;zeit;pid;txt\n" +
+			"25.10.2026 01:30:00;eT;eFill;26.10.2026;09:00;1;\"Huber Maria, Kontrolle\"\n" +
+			"25.10.2026 03:30:00;Pr;eFill;26.10.2026;10:00;2;Reception\n" +
+			"25.10.2026 04:00:00;eT;eDel;27.10.2026;8.15;3;Gruber Johann\n" +
+			"kaputt;eT;eFill;27.10.2026;8.15;3;Gruber Johann\n",
+		// database dump with the columns of the log table only
+		"2026-11.csv": "datlog,action,datum,zeit,pid,txt\n" +
+			"2026-10-25 12:00:00,eFill,2026-10-27,11:30,4,\"Wagner Anna, Erstbefund\"\n" +
+			"2026-10-25 13:00:00,eFill,,11:30,5,Steiner Karl\n",
+		"notes.txt": "ignored",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := NewCSV(dir, ParseConfig{Location: vienna})
+
+	changes, err := c.CollectChangedAppts(time.Date(2026, 10, 25, 0, 0, 0, 0, vienna))
+	if assert.NoError(t, err) && assert.Len(t, changes, 4) {
+		assert.Equal(t, "Huber Maria", changes[0].PatientName)
+		assert.Equal(t, time.Date(2026, 10, 26, 9, 0, 0, 0, vienna), changes[0].Appointment)
+
+		assert.Equal(t, 3, changes[1].PatientID)
+		assert.False(t, changes[1].IsBooking)
+		assert.Equal(t, time.Date(2026, 10, 27, 8, 15, 0, 0, vienna), changes[1].Appointment)
+
+		assert.Equal(t, "Wagner Anna", changes[2].PatientName)
+		assert.Equal(t, "datum is empty or not a date", changes[3].Error)
+	}
+
+	upcoming, err := c.CollectUpcomingAppts(time.Date(2026, 10, 26, 0, 0, 0, 0, vienna), time.Date(2026, 10, 28, 0, 0, 0, 0, vienna))
+	if assert.NoError(t, err) && assert.Len(t, upcoming, 2) {
+		assert.Equal(t, 1, upcoming[0].PatientID)
+		assert.Equal(t, 4, upcoming[1].PatientID)
+	}
+
+	_, err = NewCSV(filepath.Join(dir, "missing.csv"), ParseConfig{}).CollectChangedAppts(time.Time{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,101 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/emed-appts/emed-mailer/internal/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildDSN(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  DBConfig
+		dsn  string
+		err  bool
+	}{
+		{
+			name: "sql login with port",
+			cfg:  DBConfig{Server: "db", Port: 1433, User: "sa", Password: "secret", Database: "emed"},
+			dsn:  "sqlserver://sa:secret@db:1433?database=emed&encrypt=disable",
+		},
+		{
+			name: "named instance without port",
+			cfg:  DBConfig{Server: "db", Instance: "PRAXIS", User: "sa", Password: "secret", Database: "emed"},
+			dsn:  "sqlserver://sa:secret@db/PRAXIS?database=emed&encrypt=disable",
+		},
+		{
+			name: "instance in server setting",
+			cfg:  DBConfig{Server: `db\PRAXIS`, Port: 50123, User: "sa", Database: "emed"},
+			dsn:  "sqlserver://sa:@db:50123/PRAXIS?database=emed&encrypt=disable",
+		},
+		{
+			name: "instance given twice",
+			cfg:  DBConfig{Server: `db\PRAXIS`, Instance: "PRAXIS"},
+			err:  true,
+		},
+		{
+			name: "ntlm with domain",
+			cfg:  DBConfig{Server: "db", Auth: AuthNTLM, Domain: "PRAXIS", User: "mailer", Password: "secret", Database: "emed"},
+			dsn:  "sqlserver://PRAXIS%5Cmailer:secret@db?database=emed&encrypt=disable",
+		},
+		{
+			name: "ntlm without domain",
+			cfg:  DBConfig{Server: "db", Auth: AuthNTLM, User: "mailer"},
+			err:  true,
+		},
+		{
+			name: "unknown auth",
+			cfg:  DBConfig{Server: "db", Auth: "kerberos"},
+			err:  true,
+		},
+		{
+			name: "raw dsn",
+			cfg:  DBConfig{DSN: "sqlserver://raw", Server: "ignored"},
+			dsn:  "sqlserver://raw",
+		},
+		{
+			name: "missing server",
+			cfg:  DBConfig{},
+			err:  true,
+		},
+		{
+			name: "postgres",
+			cfg:  DBConfig{Type: config.DBTypePostgres, Server: "db", Port: 5432, User: "mailer", Password: "secret", Database: "praxis"},
+			dsn:  "postgres://mailer:secret@db:5432/praxis",
+		},
+		{
+			name: "mysql",
+			cfg:  DBConfig{Type: config.DBTypeMySQL, Server: "db", User: "mailer", Password: "secret", Database: "praxis"},
+			dsn:  "mailer:secret@tcp(db:3306)/praxis?parseTime=true",
+		},
+		{
+			name: "mysql dsn parses times",
+			cfg:  DBConfig{Type: config.DBTypeMySQL, DSN: "mailer@tcp(db)/praxis"},
+			dsn:  "mailer@tcp(db:3306)/praxis?parseTime=true",
+		},
+		{
+			name: "sqlite read only",
+			cfg:  DBConfig{Type: config.DBTypeSQLite, File: "data/praxis.db"},
+			dsn:  "file:data/praxis.db?mode=ro",
+		},
+		{
+			name: "sqlite without file",
+			cfg:  DBConfig{Type: config.DBTypeSQLite},
+			err:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dsn, err := buildDSN(tt.cfg)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.dsn, dsn)
+		})
+	}
+}
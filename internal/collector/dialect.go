@@ -0,0 +1,121 @@
+package collector
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/emed-appts/emed-mailer/internal/config"
+
+	_ "github.com/denisenkom/go-mssqldb" // import mssql for database connection
+	_ "github.com/go-sql-driver/mysql"   // import mysql for database connection
+	_ "github.com/jackc/pgx/v5/stdlib"   // import postgres for database connection
+	_ "modernc.org/sqlite"               // import sqlite for database connection
+)
+
+// queries are written with sqlserver parameters @p1, @p2, ... and bound to the dialect
+var parameterPattern = regexp.MustCompile(`@p(\d+)`)
+
+// dialect describes the differences of the supported databases
+type dialect struct {
+	// driver registered at database/sql
+	driver string
+	// placeholder returns the query parameter `n`, starting at 1
+	placeholder func(n int) string
+	// timeArg converts a time parameter, the time is a wall clock time as UTC
+	timeArg func(t time.Time) interface{}
+	// timeColumn returns the expression comparing the time column `name` with time parameters
+	timeColumn func(name string) string
+	// columnsQuery lists name and type of the columns of `table` in `schema`, schema may be empty
+	columnsQuery func(table, schema string) (string, []interface{})
+}
+
+var dialects = map[string]*dialect{
+	config.DBTypeMSSQL: {
+		driver:       "sqlserver",
+		placeholder:  numbered("@p"),
+		timeArg:      passTime,
+		timeColumn:   plainColumn,
+		columnsQuery: informationSchema(""),
+	},
+	config.DBTypePostgres: {
+		driver:       "pgx",
+		placeholder:  numbered("$"),
+		timeArg:      passTime,
+		timeColumn:   plainColumn,
+		columnsQuery: informationSchema("current_schema()"),
+	},
+	config.DBTypeMySQL: {
+		driver:       "mysql",
+		placeholder:  positional,
+		timeArg:      passTime,
+		timeColumn:   plainColumn,
+		columnsQuery: informationSchema("DATABASE()"),
+	},
+	// sqlite compares times stored as text, so columns and parameters are compared in the layout of datetime()
+	config.DBTypeSQLite: {
+		driver:      "sqlite",
+		placeholder: positional,
+		timeArg: func(t time.Time) interface{} {
+			return t.Format("2006-01-02 15:04:05")
+		},
+		timeColumn: func(name string) string {
+			return "datetime(" + name + ")"
+		},
+		columnsQuery: func(table, _ string) (string, []interface{}) {
+			return "SELECT name, type FROM pragma_table_info(@p1)", []interface{}{table}
+		},
+	},
+}
+
+// DB is a connection pool to the practice database together with its dialect
+type DB struct {
+	*sql.DB
+	dialect *dialect
+}
+
+// bind replaces the sqlserver parameters of `query` by the ones of the dialect
+func (d *dialect) bind(query string) string {
+	return parameterPattern.ReplaceAllStringFunc(query, func(p string) string {
+		n, _ := strconv.Atoi(p[2:])
+		return d.placeholder(n)
+	})
+}
+
+// numbered returns placeholders numbered after `prefix`, e.g. $1
+func numbered(prefix string) func(int) string {
+	return func(n int) string {
+		return fmt.Sprintf("%s%d", prefix, n)
+	}
+}
+
+// positional returns ? for every parameter, they are bound in order
+func positional(int) string {
+	return "?"
+}
+
+func passTime(t time.Time) interface{} {
+	return t
+}
+
+func plainColumn(name string) string {
+	return name
+}
+
+// informationSchema lists the columns by the INFORMATION_SCHEMA of the SQL standard
+// unqualified tables are looked up in the schema of the expression `current`,
+// without expression in any schema, e.g. sqlserver resolves them in the default schema and dbo
+func informationSchema(current string) func(table, schema string) (string, []interface{}) {
+	return func(table, schema string) (string, []interface{}) {
+		query := "SELECT COLUMN_NAME, DATA_TYPE FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_NAME = @p1"
+		if schema != "" {
+			return query + " AND TABLE_SCHEMA = @p2", []interface{}{table, schema}
+		}
+		if current != "" {
+			return query + " AND TABLE_SCHEMA = " + current, []interface{}{table}
+		}
+		return query, []interface{}{table}
+	}
+}
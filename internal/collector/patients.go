@@ -0,0 +1,140 @@
+package collector
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/emed-appts/emed-mailer/internal/job"
+	"github.com/emed-appts/emed-mailer/internal/metrics"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maximum number of patients queried at once, sqlserver allows 2100 parameters per query
+// and sqlite 999 before version 3.32
+const patientBatchSize = 500
+
+// PatientConfig struct encapsulate the lookup of patient data
+// table and columns are inserted into the query, so they must be validated before
+// columns besides IDColumn are optional, empty ones are not queried
+type PatientConfig struct {
+	Table        string
+	IDColumn     string
+	EmailColumn  string
+	OptOutColumn string
+
+	PhoneColumn      string
+	InsuranceColumn  string
+	NewPatientColumn string
+}
+
+type patientDirectory struct {
+	db  *DB
+	cfg PatientConfig
+}
+
+// NewPatientDirectory creates a lookup of patient data in the practice database
+func NewPatientDirectory(db *DB, cfg PatientConfig) job.PatientDirectory {
+	return &patientDirectory{db, cfg}
+}
+
+// LookupPatients loads the patients with the given ids
+// unknown patients are missing in the result
+func (directory *patientDirectory) LookupPatients(ids []int) (map[int]*job.Patient, error) {
+	patients := map[int]*job.Patient{}
+
+	unique := make([]int, 0, len(ids))
+	seen := map[int]bool{}
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+
+	for start := 0; start < len(unique); start += patientBatchSize {
+		end := start + patientBatchSize
+		if end > len(unique) {
+			end = len(unique)
+		}
+
+		if err := directory.lookupBatch(unique[start:end], patients); err != nil {
+			return nil, err
+		}
+	}
+
+	return patients, nil
+}
+
+// lookupBatch loads the patients with the given ids into `patients`
+func (directory *patientDirectory) lookupBatch(ids []int, patients map[int]*job.Patient) error {
+	// every row is scanned into the same variables, columns not configured stay empty
+	var id int
+	var email, optOut, phone, insurance, newPatient sql.NullString
+
+	columns := []string{directory.cfg.IDColumn}
+	dest := []interface{}{&id}
+	for _, c := range []struct {
+		name  string
+		value *sql.NullString
+	}{
+		{directory.cfg.EmailColumn, &email},
+		{directory.cfg.OptOutColumn, &optOut},
+		{directory.cfg.PhoneColumn, &phone},
+		{directory.cfg.InsuranceColumn, &insurance},
+		{directory.cfg.NewPatientColumn, &newPatient},
+	} {
+		if c.name != "" {
+			columns = append(columns, c.name)
+			dest = append(dest, c.value)
+		}
+	}
+
+	placeholders := make([]string, len(ids))
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		placeholders[i] = directory.db.dialect.placeholder(i + 1)
+		args[i] = id
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s IN (%s)",
+		strings.Join(columns, ", "), directory.cfg.Table, directory.cfg.IDColumn, strings.Join(placeholders, ", "))
+
+	timer := prometheus.NewTimer(metrics.QueryDuration.WithLabelValues("patients"))
+	defer timer.ObserveDuration()
+
+	rows, err := directory.db.Query(query, args...)
+	if err != nil {
+		return errors.Wrap(err, "could not execute the patient query")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return errors.Wrap(err, "could not scan patient row")
+		}
+
+		patients[id] = &job.Patient{
+			ID:     id,
+			Email:  strings.TrimSpace(email.String),
+			OptOut: isTrue(optOut.String),
+
+			Phone:      strings.TrimSpace(phone.String),
+			Insurance:  strings.TrimSpace(insurance.String),
+			NewPatient: isTrue(newPatient.String),
+		}
+	}
+
+	return errors.Wrap(rows.Err(), "patient row got an error")
+}
+
+// isTrue interprets flags stored as bit, number or text
+func isTrue(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "1", "true", "t", "y", "yes", "j", "ja", "x":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package collector
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// LogTable is the appointment log read by the collector
+const LogTable = "pds7_kallog"
+
+// types of columns the collector can scan, of sqlserver, postgres, mysql and sqlite
+var (
+	textTypes     = []string{"char", "varchar", "nchar", "nvarchar", "text", "ntext", "character", "character varying", "tinytext", "mediumtext", "longtext"}
+	intTypes      = []string{"int", "bigint", "smallint", "tinyint", "mediumint", "integer", "numeric", "decimal"}
+	dateTimeTypes = []string{"datetime", "datetime2", "smalldatetime", "datetimeoffset", "timestamp", "timestamp without time zone"}
+	dateTypes     = append([]string{"date"}, dateTimeTypes...)
+)
+
+// Column is a column of a table read by the collector
+type Column struct {
+	Name string
+	// Types lists the compatible types, any type is compatible if it is empty
+	Types []string
+}
+
+// LogColumns are the columns of the appointment log read by the collector
+var LogColumns = []Column{
+	{Name: "datlog", Types: dateTimeTypes},
+	{Name: "usc", Types: textTypes},
+	{Name: "action", Types: textTypes},
+	{Name: "datum", Types: dateTypes},
+	// the time of the appointment is parsed from text like 09:30
+	{Name: "zeit", Types: textTypes},
+	{Name: "pid", Types: intTypes},
+	{Name: "txt", Types: textTypes},
+}
+
+// PatientColumns returns the columns of the patient lookup of `cfg`
+func PatientColumns(cfg PatientConfig) []Column {
+	columns := []Column{
+		{Name: cfg.IDColumn, Types: intTypes},
+	}
+	if cfg.EmailColumn != "" {
+		columns = append(columns, Column{Name: cfg.EmailColumn, Types: textTypes})
+	}
+	if cfg.PhoneColumn != "" {
+		columns = append(columns, Column{Name: cfg.PhoneColumn, Types: textTypes})
+	}
+	// flags and the insurance are scanned as text, so bit, numbers and text are fine
+	for _, name := range []string{cfg.OptOutColumn, cfg.InsuranceColumn, cfg.NewPatientColumn} {
+		if name != "" {
+			columns = append(columns, Column{Name: name})
+		}
+	}
+	return columns
+}
+
+// ColumnCheck is the result of the check of a column
+type ColumnCheck struct {
+	Column
+	// Type of the column in the database, empty if the column is missing
+	Type string
+}
+
+// OK reports if the column exists with a compatible type
+func (c *ColumnCheck) OK() bool {
+	if c.Type == "" {
+		return false
+	}
+	if len(c.Types) == 0 {
+		return true
+	}
+	for _, t := range c.Types {
+		if strings.EqualFold(t, c.Type) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckColumns compares the columns of `table` with the `expected` ones
+// `table` may be qualified by its schema, e.g. dbo.patients, it is an error if the table does not exist
+func CheckColumns(db *DB, table string, expected []Column) ([]*ColumnCheck, error) {
+	tableName, schema := table, ""
+	if i := strings.LastIndex(table, "."); i != -1 {
+		tableName, schema = table[i+1:], table[:i]
+	}
+	query, args := db.dialect.columnsQuery(tableName, schema)
+
+	rows, err := db.Query(db.dialect.bind(query), args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not query the columns")
+	}
+	defer rows.Close()
+
+	actual := map[string]string{}
+	for rows.Next() {
+		var name, dataType string
+		if err := rows.Scan(&name, &dataType); err != nil {
+			return nil, errors.Wrap(err, "could not scan column row")
+		}
+		actual[strings.ToLower(name)] = columnType(dataType)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "column row got an error")
+	}
+	if len(actual) == 0 {
+		return nil, errors.Errorf("table %s does not exist or is not readable", table)
+	}
+
+	return compareColumns(actual, expected), nil
+}
+
+// columnType strips length and precision of declared types, e.g. VARCHAR(50)
+func columnType(dataType string) string {
+	if i := strings.Index(dataType, "("); i != -1 {
+		dataType = dataType[:i]
+	}
+	return strings.TrimSpace(dataType)
+}
+
+// compareColumns looks up the `expected` columns in the `actual` types by lower case column name
+func compareColumns(actual map[string]string, expected []Column) []*ColumnCheck {
+	checks := make([]*ColumnCheck, len(expected))
+	for i, column := range expected {
+		checks[i] = &ColumnCheck{
+			Column: column,
+			Type:   actual[strings.ToLower(column.Name)],
+		}
+	}
+	return checks
+}
@@ -0,0 +1,42 @@
+package collector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareColumns(t *testing.T) {
+	actual := map[string]string{
+		"datlog": "datetime",
+		"usc":    "varchar",
+		"action": "varchar",
+		"datum":  "date",
+		"zeit":   "time",
+		"pid":    "int",
+	}
+
+	checks := compareColumns(actual, LogColumns)
+
+	ok := map[string]bool{}
+	for _, c := range checks {
+		ok[c.Name] = c.OK()
+	}
+	assert.Equal(t, map[string]bool{
+		"datlog": true,
+		"usc":    true,
+		"action": true,
+		"datum":  true,
+		// a time column can not be parsed as text
+		"zeit": false,
+		"pid":  true,
+		// missing
+		"txt": false,
+	}, ok)
+
+	checks = compareColumns(map[string]string{"id": "bigint", "email": "nvarchar", "optout": "bit"},
+		PatientColumns(PatientConfig{IDColumn: "ID", EmailColumn: "Email", OptOutColumn: "OptOut"}))
+	for _, c := range checks {
+		assert.True(t, c.OK(), c.Name)
+	}
+}
@@ -0,0 +1,85 @@
+package collector
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/emed-appts/emed-mailer/internal/metrics"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// sqlSource reads the appointment log of the practice database
+type sqlSource struct {
+	db *DB
+}
+
+func (source *sqlSource) changed(since time.Time) ([]*logEntry, error) {
+	d := source.db.dialect
+	return source.query("changed", fmt.Sprintf("SELECT datlog, action, datum, zeit, pid, txt FROM pds7_kallog WHERE usc = 'eT' AND %s > @p1 ORDER BY datlog ASC",
+		d.timeColumn("datlog")), d.timeArg(since))
+}
+
+func (source *sqlSource) upcoming(from, to time.Time) ([]*logEntry, error) {
+	d := source.db.dialect
+	return source.query("upcoming", fmt.Sprintf("SELECT datlog, action, datum, zeit, pid, txt FROM pds7_kallog WHERE usc = 'eT' AND %[1]s >= @p1 AND %[1]s < @p2 ORDER BY datlog ASC",
+		d.timeColumn("datum")), d.timeArg(from), d.timeArg(to))
+}
+
+// query fetches log entries, its duration is observed by `name`
+func (source *sqlSource) query(name, query string, args ...interface{}) ([]*logEntry, error) {
+	timer := prometheus.NewTimer(metrics.QueryDuration.WithLabelValues(name))
+	defer timer.ObserveDuration()
+
+	stmt, err := source.db.Prepare(source.db.dialect.bind(query))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not prepare the database query")
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not execute the database query")
+	}
+	defer rows.Close()
+
+	var entries []*logEntry
+	for rows.Next() {
+		// the columns are nullable, missing values are reported by the entry instead of failing the query
+		var logTime, date sql.NullTime
+		var action, clock, txt sql.NullString
+		var pid sql.NullInt64
+
+		err := rows.Scan(&logTime, &action, &date, &clock, &pid, &txt)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not scan database row")
+		}
+
+		entry := &logEntry{
+			logTime: logTime.Time,
+			action:  action.String,
+			date:    date.Time,
+			time:    strings.TrimSpace(clock.String),
+			pid:     int(pid.Int64),
+			txt:     txt.String,
+		}
+		switch {
+		case !logTime.Valid:
+			entry.invalid = "datlog is empty"
+		case !date.Valid:
+			entry.invalid = "datum is empty"
+		case !pid.Valid:
+			entry.invalid = "pid is empty"
+		}
+		entries = append(entries, entry)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, errors.Wrap(err, "row got an error")
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,105 @@
+package collector
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emed-appts/emed-mailer/internal/collector/tzinfo"
+	"github.com/emed-appts/emed-mailer/internal/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// openTestDB creates an in-memory sqlite database with the appointment log and patients
+func openTestDB(t *testing.T) *DB {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection would get a database of its own
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	statements := []string{
+		"CREATE TABLE pds7_kallog (datlog DATETIME, usc VARCHAR(10), action VARCHAR(10), datum DATE, zeit VARCHAR(8), pid INTEGER, txt TEXT)",
+		"INSERT INTO pds7_kallog VALUES ('2026-03-28 18:00:00', 'eT', 'eFill', '2026-03-30', '09:00', 1, 'Huber Maria, Kontrolle')",
+		"INSERT INTO pds7_kallog VALUES ('2026-03-29 03:15:00', 'eT', 'eDel', '2026-03-30', '10:30', 2, 'Gruber Johann, Befund')",
+		"INSERT INTO pds7_kallog VALUES ('2026-03-29 04:00:00', 'Pr', 'eFill', '2026-03-30', '11:00', 3, 'Reception')",
+		"INSERT INTO pds7_kallog VALUES ('2026-03-29 05:00:00', 'eT', 'eFill', '2026-03-31', '9:5x', 4, 'Steiner Karl')",
+		"CREATE TABLE patients (id INTEGER, email TEXT, phone TEXT, neu INTEGER)",
+		"INSERT INTO patients VALUES (1, 'maria@example.com', '0664 1234567', 1)",
+	}
+	for _, s := range statements {
+		if _, err := db.Exec(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return &DB{db, dialects[config.DBTypeSQLite]}
+}
+
+func TestBind(t *testing.T) {
+	query := "SELECT a FROM t WHERE b > @p1 AND c < @p2 OR d = @p10"
+
+	assert.Equal(t, query, dialects[config.DBTypeMSSQL].bind(query))
+	assert.Equal(t, "SELECT a FROM t WHERE b > $1 AND c < $2 OR d = $10", dialects[config.DBTypePostgres].bind(query))
+	assert.Equal(t, "SELECT a FROM t WHERE b > ? AND c < ? OR d = ?", dialects[config.DBTypeMySQL].bind(query))
+}
+
+func TestColumnsQuery(t *testing.T) {
+	// unqualified tables are looked up in the current schema
+	query, args := dialects[config.DBTypePostgres].columnsQuery("patients", "")
+	assert.True(t, strings.HasSuffix(query, "AND TABLE_SCHEMA = current_schema()"), query)
+	assert.Equal(t, []interface{}{"patients"}, args)
+
+	query, _ = dialects[config.DBTypeMySQL].columnsQuery("patients", "")
+	assert.True(t, strings.HasSuffix(query, "AND TABLE_SCHEMA = DATABASE()"), query)
+
+	query, args = dialects[config.DBTypeMySQL].columnsQuery("patients", "praxis")
+	assert.True(t, strings.HasSuffix(query, "AND TABLE_SCHEMA = @p2"), query)
+	assert.Equal(t, []interface{}{"patients", "praxis"}, args)
+}
+
+func TestCollector_SQLite(t *testing.T) {
+	vienna, err := tzinfo.LoadLocation("Europe/Vienna")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := openTestDB(t)
+	c := New(db, ParseConfig{Location: vienna})
+
+	// datlog is the wall clock time of Vienna, after the switch to DST at 02:00
+	changes, err := c.CollectChangedAppts(time.Date(2026, 3, 29, 1, 0, 0, 0, time.UTC))
+	if assert.NoError(t, err) && assert.Len(t, changes, 2) {
+		assert.Equal(t, time.Date(2026, 3, 29, 1, 15, 0, 0, time.UTC), changes[0].Time.UTC())
+		assert.Equal(t, time.Date(2026, 3, 30, 10, 30, 0, 0, vienna), changes[0].Appointment)
+		assert.Equal(t, "Gruber Johann", changes[0].PatientName)
+		assert.False(t, changes[0].IsBooking)
+
+		assert.Equal(t, 4, changes[1].PatientID)
+		assert.NotEmpty(t, changes[1].Error)
+	}
+
+	upcoming, err := c.CollectUpcomingAppts(time.Date(2026, 3, 30, 6, 0, 0, 0, vienna), time.Date(2026, 4, 1, 0, 0, 0, 0, vienna))
+	if assert.NoError(t, err) && assert.Len(t, upcoming, 1) {
+		assert.Equal(t, 1, upcoming[0].PatientID)
+		assert.Equal(t, time.Date(2026, 3, 30, 9, 0, 0, 0, vienna), upcoming[0].Appointment)
+	}
+
+	checks, err := CheckColumns(db, LogTable, LogColumns)
+	if assert.NoError(t, err) {
+		for _, check := range checks {
+			assert.True(t, check.OK(), check.Name)
+		}
+	}
+
+	directory := NewPatientDirectory(db, PatientConfig{Table: "patients", IDColumn: "id", EmailColumn: "email", PhoneColumn: "phone", NewPatientColumn: "neu"})
+	patients, err := directory.LookupPatients([]int{1, 2, 1})
+	if assert.NoError(t, err) && assert.Len(t, patients, 1) {
+		assert.Equal(t, "maria@example.com", patients[1].Email)
+		assert.Equal(t, "0664 1234567", patients[1].Phone)
+		assert.True(t, patients[1].NewPatient)
+	}
+}
@@ -0,0 +1,61 @@
+package collector
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// TxtRule extracts structured fields from the txt column of the appointment log
+type TxtRule struct {
+	Name string
+	// Pattern has named groups surname, firstname, birthdate, phone and comment, all are optional
+	Pattern *regexp.Regexp
+	// BirthDateFormat is the layout of the birthdate group
+	BirthDateFormat string
+}
+
+// txtFields are the fields extracted from txt
+type txtFields struct {
+	surname   string
+	firstName string
+	birthDate time.Time
+	phone     string
+	comment   string
+}
+
+// parseTxt extracts the fields of `txt` by the first matching rule
+// it returns nil if no rule matches
+func parseTxt(txt string, rules []*TxtRule) *txtFields {
+	txt = strings.TrimSpace(txt)
+
+	for _, rule := range rules {
+		match := rule.Pattern.FindStringSubmatch(txt)
+		if match == nil {
+			continue
+		}
+
+		fields := &txtFields{}
+		for i, name := range rule.Pattern.SubexpNames() {
+			value := strings.TrimSpace(match[i])
+			switch name {
+			case "surname":
+				fields.surname = value
+			case "firstname":
+				fields.firstName = value
+			case "birthdate":
+				// an invalid date does not invalidate the other fields
+				if t, err := time.Parse(rule.BirthDateFormat, value); err == nil {
+					fields.birthDate = t
+				}
+			case "phone":
+				fields.phone = value
+			case "comment":
+				fields.comment = value
+			}
+		}
+		return fields
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package collector
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTxt(t *testing.T) {
+	rules := []*TxtRule{
+		{
+			Name:            "full",
+			Pattern:         regexp.MustCompile(`^(?P<surname>[^,\s]+)\s+(?P<firstname>[^,]+),\s*(?P<birthdate>[\d.]+),\s*(?P<phone>[+\d][\d /-]+?)(?:,\s*(?P<comment>.*))?$`),
+			BirthDateFormat: "02.01.2006",
+		},
+		{
+			Name:            "name",
+			Pattern:         regexp.MustCompile(`^(?P<surname>[^,\s]+)\s+(?P<firstname>[^,]+)`),
+			BirthDateFormat: "02.01.2006",
+		},
+	}
+
+	tests := []struct {
+		name   string
+		txt    string
+		fields *txtFields
+	}{
+		{
+			name: "all fields",
+			txt:  "Huber Maria, 12.03.1980, +43 664 1234567, Kontrolle nach OP",
+			fields: &txtFields{
+				surname:   "Huber",
+				firstName: "Maria",
+				birthDate: time.Date(1980, 3, 12, 0, 0, 0, 0, time.UTC),
+				phone:     "+43 664 1234567",
+				comment:   "Kontrolle nach OP",
+			},
+		},
+		{
+			name: "without comment",
+			txt:  "Gruber Johann Peter, 01.02.1955, 0664/7654321",
+			fields: &txtFields{
+				surname:   "Gruber",
+				firstName: "Johann Peter",
+				birthDate: time.Date(1955, 2, 1, 0, 0, 0, 0, time.UTC),
+				phone:     "0664/7654321",
+			},
+		},
+		{
+			name: "comment with commas",
+			txt:  "Wagner Anna, 23.11.1987, 01 2345678, Impfung, bitte Pass mitbringen",
+			fields: &txtFields{
+				surname:   "Wagner",
+				firstName: "Anna",
+				birthDate: time.Date(1987, 11, 23, 0, 0, 0, 0, time.UTC),
+				phone:     "01 2345678",
+				comment:   "Impfung, bitte Pass mitbringen",
+			},
+		},
+		{
+			name: "invalid birthdate is dropped",
+			txt:  "Bauer Franz, 31.02.1970, 0664 1111111",
+			fields: &txtFields{
+				surname:   "Bauer",
+				firstName: "Franz",
+				phone:     "0664 1111111",
+			},
+		},
+		{
+			name: "fallback to the next rule",
+			txt:  "Steiner Karl, Erstordination",
+			fields: &txtFields{
+				surname:   "Steiner",
+				firstName: "Karl",
+			},
+		},
+		{
+			name: "no rule matches",
+			txt:  "Steiner",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.fields, parseTxt(tt.txt, rules))
+		})
+	}
+
+	assert.Nil(t, parseTxt("Huber Maria", nil))
+}
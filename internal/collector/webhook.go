@@ -0,0 +1,502 @@
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emed-appts/emed-mailer/internal/job"
+	"github.com/emed-appts/emed-mailer/internal/metrics"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+// headers of requests of booking portals
+const (
+	// WebhookTimestampHeader holds the unix time the request was signed at
+	WebhookTimestampHeader = "X-Emed-Timestamp"
+	// WebhookSignatureHeader holds sha256=<hex of HMAC-SHA256 of "<timestamp>.<body>">
+	WebhookSignatureHeader = "X-Emed-Signature"
+)
+
+// WebhookPath is the path events are posted to
+const WebhookPath = "/events"
+
+// actions of events
+const (
+	webhookBooking      = "booking"
+	webhookCancellation = "cancellation"
+)
+
+// maxWebhookBody limits the size of an event
+const maxWebhookBody = 64 << 10
+
+// webhookPruneInterval is the time after which storing an event removes the expired ones again
+const webhookPruneInterval = 24 * time.Hour
+
+// WebhookConfig struct encapsulate the endpoint receiving events of booking portals
+type WebhookConfig struct {
+	// Secret signs the requests
+	Secret string
+	// Tolerance is the maximum age of a signature, older requests are rejected as replays
+	Tolerance time.Duration
+	// Retention is the time events of past appointments are kept
+	Retention time.Duration
+	// File stores the received events, one JSON object per line
+	File string
+}
+
+// webhookEvent is a booking or cancellation posted by a booking portal
+type webhookEvent struct {
+	// ID identifies the event, events are stored once if the portal retries
+	ID          string    `json:"id"`
+	Action      string    `json:"action"`
+	Appointment time.Time `json:"appointment"`
+	PatientID   int       `json:"patientId"`
+	PatientName string    `json:"patientName"`
+	Comment     string    `json:"comment,omitempty"`
+
+	// Received is set when the event is stored, the changes of a run are selected by it
+	Received time.Time `json:"received"`
+}
+
+// Webhook receives events of booking portals over http and collects the appointment changes of them
+// events are stored in a file, so they survive restarts and are read by all commands
+type Webhook struct {
+	cfg   WebhookConfig
+	parse ParseConfig
+
+	mu sync.Mutex
+	// ids of the stored events, loaded by Open
+	ids map[string]bool
+	// pruned is the time the expired events were removed
+	pruned time.Time
+}
+
+// NewWebhook creates a collector instance of the events received by the webhook
+// the stored events are read on every collection, Open prepares the file to receive events
+// the times of the events are instants, only TxtRules and Location of `parse` apply
+func NewWebhook(cfg WebhookConfig, parse ParseConfig) *Webhook {
+	if parse.Location == nil {
+		parse.Location = time.Local
+	}
+
+	return &Webhook{
+		cfg:   cfg,
+		parse: parse,
+	}
+}
+
+// Open repairs an event interrupted while being written and removes expired events
+// it must be called before serving requests, by the only process receiving events
+// storing events removes the expired ones again once a day
+func (wh *Webhook) Open() error {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+
+	return wh.prune()
+}
+
+// prune rewrites the file without expired events and the ids of the stored ones
+// an event interrupted while being written is removed
+func (wh *Webhook) prune() error {
+	wh.pruned = time.Now()
+
+	content, err := os.ReadFile(wh.cfg.File)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "could not read webhook events")
+	}
+
+	complete := content
+	if i := bytes.LastIndexByte(content, '\n'); i != len(content)-1 {
+		complete = content[:i+1]
+		log.Warn().
+			Str("file", wh.cfg.File).
+			Msg("removed webhook event interrupted while being written")
+	}
+
+	events, err := decodeEvents(complete, wh.cfg.File)
+	if err != nil {
+		return err
+	}
+
+	expiry := wh.pruned.Add(-wh.cfg.Retention)
+	var kept bytes.Buffer
+	var removed int
+	wh.ids = map[string]bool{}
+	for _, event := range events {
+		if event.Received.Before(expiry) && event.Appointment.Before(expiry) {
+			removed++
+			continue
+		}
+		wh.ids[event.ID] = true
+		if err := encodeEvent(&kept, event); err != nil {
+			return err
+		}
+	}
+
+	if bytes.Equal(kept.Bytes(), content) {
+		return nil
+	}
+	if removed > 0 {
+		log.Info().
+			Int("count", removed).
+			Msg("removed expired webhook events")
+	}
+
+	tmp := wh.cfg.File + ".tmp"
+	if err := writeSynced(tmp, kept.Bytes()); err != nil {
+		return err
+	}
+	return errors.Wrap(os.Rename(tmp, wh.cfg.File), "could not replace webhook events")
+}
+
+// Check reports if events can be stored
+func (wh *Webhook) Check() error {
+	_, err := os.Stat(filepath.Dir(wh.cfg.File))
+	return err
+}
+
+// ServeHTTP stores a signed event posted to WebhookPath
+// the portal should retry on 5xx responses, an event is stored once per id
+func (wh *Webhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != WebhookPath {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+	if err != nil {
+		metrics.WebhookEvents.WithLabelValues("invalid").Inc()
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if err := wh.verify(r.Header, body, time.Now()); err != nil {
+		metrics.WebhookEvents.WithLabelValues("unauthorized").Inc()
+		log.Warn().
+			Err(err).
+			Str("remote", r.RemoteAddr).
+			Msg("rejected webhook request")
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	event, err := parseEvent(body)
+	if err != nil {
+		metrics.WebhookEvents.WithLabelValues("invalid").Inc()
+		log.Warn().
+			Err(err).
+			Str("remote", r.RemoteAddr).
+			Msg("rejected invalid webhook event")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	stored, err := wh.store(event)
+	if err != nil {
+		metrics.WebhookEvents.WithLabelValues("failed").Inc()
+		log.Error().
+			Err(err).
+			Str("id", event.ID).
+			Msg("could not store webhook event")
+		http.Error(w, "could not store event", http.StatusInternalServerError)
+		return
+	}
+
+	if !stored {
+		metrics.WebhookEvents.WithLabelValues("duplicate").Inc()
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, "duplicate")
+		return
+	}
+
+	metrics.WebhookEvents.WithLabelValues("accepted").Inc()
+	log.Debug().
+		Str("id", event.ID).
+		Str("action", event.Action).
+		Msg("stored webhook event")
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintln(w, "accepted")
+}
+
+// verify checks the signature of `body` and that it was signed within the tolerance of `now`
+func (wh *Webhook) verify(header http.Header, body []byte, now time.Time) error {
+	timestamp := header.Get(WebhookTimestampHeader)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.Errorf("%s missing or not a unix time", WebhookTimestampHeader)
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > wh.cfg.Tolerance || age < -wh.cfg.Tolerance {
+		return errors.Errorf("%s is off by %s", WebhookTimestampHeader, age.Round(time.Second))
+	}
+
+	signature, err := hex.DecodeString(strings.TrimPrefix(header.Get(WebhookSignatureHeader), "sha256="))
+	if err != nil || len(signature) == 0 {
+		return errors.Errorf("%s missing or not hex encoded", WebhookSignatureHeader)
+	}
+	if !hmac.Equal(signature, signWebhook([]byte(wh.cfg.Secret), timestamp, body)) {
+		return errors.Errorf("%s does not match", WebhookSignatureHeader)
+	}
+	return nil
+}
+
+// signWebhook returns the HMAC-SHA256 of "<timestamp>.<body>"
+func signWebhook(secret []byte, timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// store appends `event` to the file unless an event with its id is stored already
+// the file is synced before the event is acknowledged
+func (wh *Webhook) store(event *webhookEvent) (bool, error) {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+
+	if wh.ids == nil {
+		return false, errors.New("webhook events are not opened")
+	}
+	if wh.ids[event.ID] {
+		return false, nil
+	}
+
+	event.Received = time.Now()
+	var line bytes.Buffer
+	if err := encodeEvent(&line, event); err != nil {
+		return false, err
+	}
+
+	f, err := os.OpenFile(wh.cfg.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return false, errors.Wrap(err, "could not open webhook events")
+	}
+	if _, err := f.Write(line.Bytes()); err != nil {
+		f.Close()
+		return false, errors.Wrap(err, "could not write webhook event")
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return false, errors.Wrap(err, "could not sync webhook events")
+	}
+	if err := f.Close(); err != nil {
+		return false, errors.Wrap(err, "could not close webhook events")
+	}
+
+	wh.ids[event.ID] = true
+
+	if time.Since(wh.pruned) >= webhookPruneInterval {
+		if err := wh.prune(); err != nil {
+			log.Error().
+				Err(err).
+				Msg("could not remove expired webhook events")
+		}
+	}
+	return true, nil
+}
+
+// parseEvent decodes and validates a posted event
+func parseEvent(body []byte) (*webhookEvent, error) {
+	event := &webhookEvent{}
+	if err := json.Unmarshal(body, event); err != nil {
+		return nil, errors.Wrap(err, "invalid JSON")
+	}
+
+	var problems []string
+	if event.ID = strings.TrimSpace(event.ID); event.ID == "" || len(event.ID) > 200 {
+		problems = append(problems, "id is required and limited to 200 characters")
+	}
+	if event.Action != webhookBooking && event.Action != webhookCancellation {
+		problems = append(problems, fmt.Sprintf("action must be %s or %s", webhookBooking, webhookCancellation))
+	}
+	if event.Appointment.IsZero() {
+		problems = append(problems, "appointment is required")
+	}
+	if event.PatientID <= 0 {
+		problems = append(problems, "patientId must be a positive number")
+	}
+	// the name is the text before the first comma of the log entry, like the ones of the database
+	event.PatientName = strings.Join(strings.Fields(strings.ReplaceAll(event.PatientName, ",", " ")), " ")
+	if event.PatientName == "" {
+		problems = append(problems, "patientName is required")
+	}
+	if len(problems) > 0 {
+		return nil, errors.New(strings.Join(problems, ", "))
+	}
+
+	event.Received = time.Time{}
+	return event, nil
+}
+
+// CollectChangedAppts gathers the events received after `lastRun`
+func (wh *Webhook) CollectChangedAppts(lastRun time.Time) ([]*job.ApptChange, error) {
+	timer := prometheus.NewTimer(metrics.QueryDuration.WithLabelValues("changed"))
+	events, err := wh.read()
+	timer.ObserveDuration()
+	if err != nil {
+		return nil, err
+	}
+
+	var changedAppts []*job.ApptChange
+	for _, event := range events {
+		if !event.Received.After(lastRun) {
+			continue
+		}
+		change := wh.change(event)
+		changedAppts = append(changedAppts, change)
+
+		if change.IsBooking {
+			metrics.ChangesCollected.WithLabelValues("booking").Inc()
+		} else {
+			metrics.ChangesCollected.WithLabelValues("cancellation").Inc()
+		}
+	}
+	return changedAppts, nil
+}
+
+// CollectUpcomingAppts gathers the booked appointments from `from` until `to`
+// an appointment is booked if its latest event is a booking
+func (wh *Webhook) CollectUpcomingAppts(from, to time.Time) ([]*job.UpcomingAppt, error) {
+	timer := prometheus.NewTimer(metrics.QueryDuration.WithLabelValues("upcoming"))
+	events, err := wh.read()
+	timer.ObserveDuration()
+	if err != nil {
+		return nil, err
+	}
+
+	type slot struct {
+		appointment int64
+		pid         int
+	}
+	latest := map[slot]*webhookEvent{}
+	for _, event := range events {
+		latest[slot{event.Appointment.Unix(), event.PatientID}] = event
+	}
+
+	var upcomingAppts []*job.UpcomingAppt
+	for _, event := range latest {
+		if event.Action != webhookBooking || event.Appointment.Before(from) || !event.Appointment.Before(to) {
+			continue
+		}
+
+		upcomingAppts = append(upcomingAppts, &job.UpcomingAppt{
+			Appointment: event.Appointment.In(wh.parse.Location),
+			BookedAt:    event.Received.In(wh.parse.Location),
+			PatientID:   event.PatientID,
+			PatientName: event.PatientName,
+		})
+	}
+
+	sort.Slice(upcomingAppts, func(i, j int) bool {
+		return upcomingAppts[i].Appointment.Before(upcomingAppts[j].Appointment)
+	})
+
+	return upcomingAppts, nil
+}
+
+// read loads the stored events in the order they were received
+// an event still being written by the daemon is skipped
+func (wh *Webhook) read() ([]*webhookEvent, error) {
+	content, err := os.ReadFile(wh.cfg.File)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read webhook events")
+	}
+
+	return decodeEvents(content[:bytes.LastIndexByte(content, '\n')+1], wh.cfg.File)
+}
+
+// change converts `event` to an appointment change in the time zone of the database
+// the comment is parsed by the txt rules like the txt of a log entry
+func (wh *Webhook) change(event *webhookEvent) *job.ApptChange {
+	change := &job.ApptChange{
+		Time:        event.Received.In(wh.parse.Location),
+		Appointment: event.Appointment.In(wh.parse.Location),
+		PatientID:   event.PatientID,
+		PatientName: event.PatientName,
+		IsBooking:   event.Action == webhookBooking,
+	}
+
+	txt := event.PatientName
+	if event.Comment != "" {
+		txt += ", " + event.Comment
+	}
+	if fields := parseTxt(txt, wh.parse.TxtRules); fields != nil {
+		change.Surname = fields.surname
+		change.FirstName = fields.firstName
+		change.BirthDate = fields.birthDate
+		change.Phone = fields.phone
+		change.Comment = fields.comment
+	}
+	return change
+}
+
+// decodeEvents decodes the events of `content`, one per line
+func decodeEvents(content []byte, file string) ([]*webhookEvent, error) {
+	var events []*webhookEvent
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(nil, 2*maxWebhookBody)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		event := &webhookEvent{}
+		if err := json.Unmarshal(scanner.Bytes(), event); err != nil {
+			return nil, errors.Wrapf(err, "could not decode webhook event in line %d of %s", line, file)
+		}
+		events = append(events, event)
+	}
+	return events, errors.Wrap(scanner.Err(), "could not read webhook events")
+}
+
+// encodeEvent writes `event` as a line to `buf`
+func encodeEvent(buf *bytes.Buffer, event *webhookEvent) error {
+	content, err := json.Marshal(event)
+	if err != nil {
+		return errors.Wrap(err, "could not encode webhook event")
+	}
+	buf.Write(content)
+	buf.WriteByte('\n')
+	return nil
+}
+
+// writeSynced writes `content` to `file` and syncs it to disk
+func writeSynced(file string, content []byte) error {
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
+	if err != nil {
+		return errors.Wrap(err, "could not create webhook events")
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return errors.Wrap(err, "could not write webhook events")
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return errors.Wrap(err, "could not sync webhook events")
+	}
+	return errors.Wrap(f.Close(), "could not close webhook events")
+}
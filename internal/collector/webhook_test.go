@@ -0,0 +1,142 @@
+package collector
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emed-appts/emed-mailer/internal/collector/tzinfo"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhook(t *testing.T) {
+	vienna, err := tzinfo.LoadLocation("Europe/Vienna")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(t.TempDir(), "webhook.events.jsonl")
+	// an expired event and one interrupted while being written
+	content := `{"id":"old","action":"booking","appointment":"2020-01-02T09:00:00+01:00","patientId":9,"patientName":"Alt","received":"2020-01-01T10:00:00Z"}` + "\n" + `{"id":"torn","act`
+	if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := WebhookConfig{Secret: "0123456789abcdef", Tolerance: 5 * time.Minute, Retention: 90 * 24 * time.Hour, File: file}
+	wh := NewWebhook(cfg, ParseConfig{Location: vienna})
+	if !assert.NoError(t, wh.Open()) {
+		return
+	}
+	stored, _ := os.ReadFile(file)
+	assert.Empty(t, stored)
+
+	post := func(body, secret string, signedAt time.Time) *httptest.ResponseRecorder {
+		timestamp := strconv.FormatInt(signedAt.Unix(), 10)
+		r := httptest.NewRequest(http.MethodPost, WebhookPath, strings.NewReader(body))
+		r.Header.Set(WebhookTimestampHeader, timestamp)
+		r.Header.Set(WebhookSignatureHeader, "sha256="+hex.EncodeToString(signWebhook([]byte(secret), timestamp, []byte(body))))
+		w := httptest.NewRecorder()
+		wh.ServeHTTP(w, r)
+		return w
+	}
+
+	start := time.Now().Add(-time.Second)
+	booking := `{"id":"evt-1","action":"booking","appointment":"2026-10-26T09:00:00+01:00","patientId":1,"patientName":"Huber, Maria","comment":"Kontrolle"}`
+	cancellation := `{"id":"evt-2","action":"cancellation","appointment":"2026-10-27T08:15:00Z","patientId":3,"patientName":"Gruber Johann"}`
+
+	assert.Equal(t, http.StatusAccepted, post(booking, cfg.Secret, time.Now()).Code)
+	assert.Equal(t, http.StatusOK, post(booking, cfg.Secret, time.Now()).Code, "retry is stored once")
+	assert.Equal(t, http.StatusAccepted, post(cancellation, cfg.Secret, time.Now()).Code)
+
+	assert.Equal(t, http.StatusUnauthorized, post(booking, "another secret!!", time.Now()).Code)
+	assert.Equal(t, http.StatusUnauthorized, post(booking, cfg.Secret, time.Now().Add(-time.Hour)).Code, "replay")
+
+	w := post(`{"id":"evt-3","action":"moved","patientId":0}`, cfg.Secret, time.Now())
+	if assert.Equal(t, http.StatusBadRequest, w.Code) {
+		assert.Contains(t, w.Body.String(), "action must be booking or cancellation")
+		assert.Contains(t, w.Body.String(), "appointment is required")
+		assert.Contains(t, w.Body.String(), "patientName is required")
+	}
+
+	r := httptest.NewRequest(http.MethodGet, WebhookPath, nil)
+	w = httptest.NewRecorder()
+	wh.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+	// a collector of another process reads the stored events
+	c := NewWebhook(cfg, ParseConfig{Location: vienna})
+
+	changes, err := c.CollectChangedAppts(start)
+	if assert.NoError(t, err) && assert.Len(t, changes, 2) {
+		assert.Equal(t, "Huber Maria", changes[0].PatientName)
+		assert.True(t, changes[0].IsBooking)
+		assert.Equal(t, time.Date(2026, 10, 26, 9, 0, 0, 0, vienna), changes[0].Appointment)
+
+		assert.Equal(t, 3, changes[1].PatientID)
+		assert.False(t, changes[1].IsBooking)
+		assert.Equal(t, time.Date(2026, 10, 27, 9, 15, 0, 0, vienna), changes[1].Appointment)
+	}
+
+	changes, err = c.CollectChangedAppts(time.Now())
+	if assert.NoError(t, err) {
+		assert.Empty(t, changes)
+	}
+
+	upcoming, err := c.CollectUpcomingAppts(time.Date(2026, 10, 26, 0, 0, 0, 0, vienna), time.Date(2026, 10, 28, 0, 0, 0, 0, vienna))
+	if assert.NoError(t, err) && assert.Len(t, upcoming, 1) {
+		assert.Equal(t, 1, upcoming[0].PatientID)
+	}
+}
+
+func TestWebhook_ReceivedAtDSTChange(t *testing.T) {
+	vienna, err := tzinfo.LoadLocation("Europe/Vienna")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// both events are received at 02:30 local time, before and after the clocks are set back
+	file := filepath.Join(t.TempDir(), "webhook.events.jsonl")
+	content := `{"id":"cest","action":"booking","appointment":"2026-10-26T09:00:00+01:00","patientId":1,"patientName":"Huber Maria","received":"2026-10-25T00:30:00Z"}` + "\n" +
+		`{"id":"cet","action":"booking","appointment":"2026-10-26T10:00:00+01:00","patientId":2,"patientName":"Gruber Johann","received":"2026-10-25T01:30:00Z"}` + "\n"
+	if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	wh := NewWebhook(WebhookConfig{File: file}, ParseConfig{Location: vienna})
+	changes, err := wh.CollectChangedAppts(time.Date(2026, 10, 25, 0, 45, 0, 0, time.UTC))
+	if assert.NoError(t, err) && assert.Len(t, changes, 1) {
+		assert.Equal(t, 2, changes[0].PatientID)
+		assert.True(t, changes[0].Time.Equal(time.Date(2026, 10, 25, 1, 30, 0, 0, time.UTC)))
+	}
+}
+
+func TestWebhook_Prune(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "webhook.events.jsonl")
+	cfg := WebhookConfig{Secret: "0123456789abcdef", Tolerance: 5 * time.Minute, Retention: 24 * time.Hour, File: file}
+	wh := NewWebhook(cfg, ParseConfig{Location: time.UTC})
+	if !assert.NoError(t, wh.Open()) {
+		return
+	}
+
+	// an event expiring while the daemon runs is removed when a later event is stored
+	expired := `{"id":"old","action":"booking","appointment":"2020-01-02T09:00:00Z","patientId":9,"patientName":"Alt","received":"2020-01-01T10:00:00Z"}` + "\n"
+	if err := os.WriteFile(file, []byte(expired), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	wh.pruned = time.Now().Add(-webhookPruneInterval)
+
+	stored, err := wh.store(&webhookEvent{ID: "new", Action: webhookBooking, Appointment: time.Now().Add(time.Hour), PatientID: 1, PatientName: "Neu"})
+	assert.NoError(t, err)
+	assert.True(t, stored)
+
+	content, _ := os.ReadFile(file)
+	assert.NotContains(t, string(content), `"old"`)
+	assert.Contains(t, string(content), `"new"`)
+}
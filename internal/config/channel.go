@@ -0,0 +1,156 @@
+package config
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/emed-appts/emed-mailer/internal/secret"
+
+	"gopkg.in/ini.v1"
+)
+
+// ChannelSectionPrefix starts the names of channel sections, e.g. [channel.sms]
+const ChannelSectionPrefix = "channel."
+
+// MailChannel is the name of the built-in channel sending mails configured by [mail]
+const MailChannel = "mail"
+
+// channel types
+const (
+	ChannelEmail   = "email"
+	ChannelSMS     = "sms"
+	ChannelWebhook = "webhook"
+	ChannelMatrix  = "matrix"
+)
+
+// channel names are referenced by the CHANNELS of jobs, so they are restricted like job names
+var channelNamePattern = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// channel defines a notification channel, read from a section [channel.<name>]
+type channel struct {
+	Name string `ini:"-"`
+	Type string `ini:"TYPE"`
+
+	URL   string `ini:"URL"`
+	Token string `ini:"TOKEN"`
+
+	To      string `ini:"TO"`
+	Subject string `ini:"SUBJECT"`
+
+	ToField   string `ini:"TO_FIELD"`
+	TextField string `ini:"TEXT_FIELD"`
+	MaxLength int    `ini:"MAX_LENGTH"`
+
+	Username string `ini:"USERNAME"`
+}
+
+func newChannel(name string) *channel {
+	return &channel{
+		Name:      name,
+		ToField:   "to",
+		TextField: "text",
+		Username:  "emed-mailer",
+	}
+}
+
+// addChannelSections creates a channel for every channel section of the config file
+func (next *Snapshot) addChannelSections(cfg *ini.File) {
+	for _, section := range cfg.Sections() {
+		if strings.HasPrefix(section.Name(), ChannelSectionPrefix) {
+			next.Channels = append(next.Channels, newChannel(strings.TrimPrefix(section.Name(), ChannelSectionPrefix)))
+		}
+	}
+}
+
+// smsMaxLength is the default MAX_LENGTH of sms channels, the length of a single sms
+const smsMaxLength = 160
+
+// mapChannels maps the channel sections
+// texts of sms channels are limited to a single sms if MAX_LENGTH is not set
+func (next *Snapshot) mapChannels(cfg *ini.File) error {
+	for _, c := range next.Channels {
+		section := cfg.Section(ChannelSectionPrefix + c.Name)
+		if err := section.MapTo(c); err != nil {
+			return err
+		}
+		if c.Type == ChannelSMS && !section.HasKey("MAX_LENGTH") {
+			c.MaxLength = smsMaxLength
+		}
+	}
+	return nil
+}
+
+// validateChannels checks the channel configuration and the channels used by jobs
+func validateChannels(next *Snapshot, problems *ValidationError) {
+	known := map[string]bool{MailChannel: true}
+
+	for _, c := range next.Channels {
+		section := ChannelSectionPrefix + c.Name
+		known[c.Name] = true
+
+		switch {
+		case c.Name == MailChannel:
+			problems.add(section, "", "channel name %q is reserved for the [mail] section", MailChannel)
+		case !channelNamePattern.MatchString(c.Name):
+			problems.add(section, "", "invalid channel name %q, only lowercase letters, digits, - and _ are allowed", c.Name)
+		}
+
+		switch c.Type {
+		case ChannelEmail:
+			if c.To != "" {
+				checkAddressList(problems, section, "TO", c.To)
+			}
+		case ChannelSMS:
+			checkURL(problems, section, "URL", c.URL)
+			checkRequired(problems, section, "TO", c.To)
+			checkRequired(problems, section, "TO_FIELD", c.ToField)
+			checkRequired(problems, section, "TEXT_FIELD", c.TextField)
+		case ChannelWebhook, ChannelMatrix:
+			checkURL(problems, section, "URL", c.URL)
+		default:
+			problems.add(section, "TYPE", "unknown channel type %q, expected %s, %s, %s or %s", c.Type, ChannelEmail, ChannelSMS, ChannelWebhook, ChannelMatrix)
+		}
+
+		if c.MaxLength < 0 {
+			problems.add(section, "MAX_LENGTH", "must not be negative")
+		}
+	}
+
+	for _, j := range next.Jobs {
+		// patients are notified by mail only
+		if j.Type == TypePatients {
+			continue
+		}
+
+		section := "general"
+		if !next.defaultJob {
+			section = JobSectionPrefix + j.Name
+		}
+
+		names := j.ChannelNames()
+		if len(names) == 0 {
+			problems.add(section, "CHANNELS", "required")
+		}
+		for _, name := range names {
+			if !known[name] {
+				problems.add(section, "CHANNELS", "unknown channel %q, define it in a [%s%s] section", name, ChannelSectionPrefix, name)
+			}
+		}
+	}
+}
+
+// checkURL reports a missing or invalid http(s) url
+func checkURL(problems *ValidationError, section, key, value string) {
+	if value == "" {
+		problems.add(section, key, "required")
+		return
+	}
+	// secret references are checked after resolving them
+	if secret.IsReference(value) {
+		return
+	}
+	if u, err := url.Parse(value); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		problems.add(section, key, "invalid url, expected http(s)://host/path")
+	}
+}
@@ -8,26 +8,27 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/emed-appts/emed-mailer/internal/collector/tzinfo"
+	"github.com/emed-appts/emed-mailer/internal/secret"
+
 	_ "github.com/kardianos/minwinsvc" // import minwinsvc for windows services
 	"github.com/pkg/errors"
 	"github.com/robfig/cron/v3"
 	"gopkg.in/ini.v1"
 )
 
+// DefaultTimeZone is the time zone of the database if none is configured
+const DefaultTimeZone = "Europe/Vienna"
+
 var (
 	// Path of config file
 	Path string
 
-	// General config
-	General = &general{}
-	// Mail config
-	Mail = &mail{}
-	// DB config
-	DB = &db{}
-	// Log config
-	Log = &log{}
+	// active is the configuration in use, replaced at once by Load
+	active atomic.Pointer[Snapshot]
 
 	// AppWorkPath of binary
 	AppWorkPath string
@@ -37,9 +38,11 @@ var (
 // general defines the general configuration.
 type general struct {
 	Root           string        `ini:"ROOT"`
+	Templates      string        `ini:"TEMPLATES"`
 	CronExpression string        `ini:"SCHEDULE"`
-	Schedule       cron.Schedule `ini:"-"`
-	Interval       time.Duration `ini:"-"`
+	MinGap         time.Duration `ini:"MIN_GAP"`
+	// TimeZone of the messages and the days of jobs, empty uses the time zone of [db]
+	TimeZone string `ini:"TIMEZONE"`
 }
 
 // mail defines the mailer configuration.
@@ -52,16 +55,112 @@ type mail struct {
 	From    string `ini:"FROM"`
 	To      string `ini:"TO"`
 	Subject string `ini:"SUBJECT"`
+
+	RateLimit int `ini:"RATE_LIMIT"`
 }
 
+// database types selected by [db] TYPE, the collector selects driver and dialect by them
+const (
+	DBTypeMSSQL    = "mssql"
+	DBTypePostgres = "postgres"
+	DBTypeMySQL    = "mysql"
+	DBTypeSQLite   = "sqlite"
+	// DBTypeCSV reads exported appointment logs instead of a database
+	DBTypeCSV = "csv"
+	// DBTypeWebhook reads the events booking portals posted to the webhook instead of a database
+	DBTypeWebhook = "webhook"
+)
+
 // db defines the database configuration.
 type db struct {
+	Type string `ini:"TYPE"`
+	DSN  string `ini:"DSN"`
+	// File is the database file of sqlite or the exported logs of csv
+	File string `ini:"FILE"`
+
 	Server   string `ini:"SERVER"`
+	Instance string `ini:"INSTANCE"`
 	Port     int    `ini:"PORT"`
+
+	Auth     string `ini:"AUTH"`
+	Domain   string `ini:"DOMAIN"`
 	User     string `ini:"USER"`
 	Password string `ini:"PASSWORD"`
+	SPN      string `ini:"SPN"`
 
 	Database string `ini:"DATABASE"`
+
+	// TimeFormats are comma separated layouts of the time of an appointment, empty uses the defaults
+	TimeFormats string `ini:"TIME_FORMATS"`
+	// TimeZone of the wall clock times stored in the database
+	TimeZone string `ini:"TIMEZONE"`
+}
+
+// Location returns the time zone of the wall clock times stored in the database
+func (d *db) Location() *time.Location {
+	return location(d.TimeZone)
+}
+
+// location loads the time zone `name`, it has been checked by validate
+// UTC is returned if it can not be loaded anyway
+func location(name string) *time.Location {
+	loc, err := tzinfo.LoadLocation(name)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// TimeFormatList returns the layouts of the time of an appointment
+func (d *db) TimeFormatList() []string {
+	var formats []string
+	for _, format := range strings.Split(d.TimeFormats, ",") {
+		if format = strings.TrimSpace(format); format != "" {
+			formats = append(formats, format)
+		}
+	}
+	return formats
+}
+
+// patients defines the lookup of patient data in the practice database.
+// the master data columns enrich the changes of jobs with ENRICH
+type patients struct {
+	Table        string `ini:"TABLE"`
+	IDColumn     string `ini:"ID_COLUMN"`
+	EmailColumn  string `ini:"EMAIL_COLUMN"`
+	OptOutColumn string `ini:"OPT_OUT_COLUMN"`
+
+	PhoneColumn      string `ini:"PHONE_COLUMN"`
+	InsuranceColumn  string `ini:"INSURANCE_COLUMN"`
+	NewPatientColumn string `ini:"NEW_PATIENT_COLUMN"`
+}
+
+// alert defines the alerts about failing jobs.
+// without SERVER the mail server of [mail] is used
+type alert struct {
+	To    string `ini:"TO"`
+	After int    `ini:"AFTER"`
+
+	Server   string `ini:"SERVER"`
+	Port     int    `ini:"PORT"`
+	User     string `ini:"USER"`
+	Password string `ini:"PASSWORD"`
+	From     string `ini:"FROM"`
+}
+
+// httpServer defines the embedded http server of the status and health endpoints.
+type httpServer struct {
+	Listen string `ini:"LISTEN"`
+}
+
+// webhook defines the endpoint receiving events of booking portals with [db] TYPE webhook.
+type webhook struct {
+	Listen string `ini:"LISTEN"`
+	Secret string `ini:"SECRET"`
+	// Tolerance is the maximum age of a signature
+	Tolerance time.Duration `ini:"TOLERANCE"`
+	// Retention is the time events of past appointments are kept
+	Retention time.Duration `ini:"RETENTION"`
 }
 
 // log defines the logging configuration.
@@ -71,7 +170,73 @@ type log struct {
 	Pretty  bool   `ini:"PRETTY"`
 }
 
+// Snapshot holds a complete configuration
+// it is not modified once it is active, readers take the active one by Current once
+// so all the values they read belong to the same configuration
+type Snapshot struct {
+	General  *general
+	Mail     *mail
+	DB       *db
+	Log      *log
+	Patients *patients
+	HTTP     *httpServer
+	Alert    *alert
+	Webhook  *webhook
+	Jobs     []*job
+	Channels []*channel
+	// TxtRules in order of the config file
+	TxtRules []*txtRule
+
+	// Settings lists the effective value and source of every config key
+	// secrets are redacted
+	Settings []Setting
+
+	// defaultJob is set if the only job is defined by [general] SCHEDULE
+	defaultJob bool
+}
+
+func init() {
+	active.Store(newSnapshot())
+}
+
+func newSnapshot() *Snapshot {
+	return &Snapshot{
+		General: &general{},
+		Mail:    &mail{},
+		DB: &db{
+			Type:     DBTypeMSSQL,
+			TimeZone: DefaultTimeZone,
+		},
+		Log: &log{},
+
+		Patients: &patients{},
+		HTTP:     &httpServer{},
+		Alert: &alert{
+			After: 3,
+		},
+		Webhook: &webhook{
+			Tolerance: 5 * time.Minute,
+			Retention: 90 * 24 * time.Hour,
+		},
+	}
+}
+
+// Current returns the active configuration
+func Current() *Snapshot {
+	return active.Load()
+}
+
+// Location returns the time zone of the messages and the days of jobs
+func (s *Snapshot) Location() *time.Location {
+	if s.General.TimeZone == "" {
+		return s.DB.Location()
+	}
+	return location(s.General.TimeZone)
+}
+
 // Load loads the configuration from `Path`
+// the active configuration is only replaced if the loaded one is valid,
+// so Load can be called again to reload the configuration
 func Load() error {
 	isWindows = runtime.GOOS == "windows"
 
@@ -92,49 +257,206 @@ func Load() error {
 		return errors.Wrap(err, "could not load ini config")
 	}
 
-	if err = config.Section("general").MapTo(General); err != nil {
+	next := newSnapshot()
+	next.addJobSections(config)
+	next.addChannelSections(config)
+	next.addTxtSections(config)
+
+	sources, err := applyOverrides(config, next)
+	if err != nil {
+		return errors.Wrap(err, "could not apply overrides")
+	}
+
+	if err = config.Section("general").MapTo(next.General); err != nil {
 		return errors.Wrap(err, "could not map general section")
 	}
 
-	if !filepath.IsAbs(General.Root) {
-		General.Root = path.Join(AppWorkPath, General.Root)
+	if err = config.Section("mail").MapTo(next.Mail); err != nil {
+		return errors.Wrap(err, "could not map mail section")
+	}
+
+	if err = config.Section("db").MapTo(next.DB); err != nil {
+		return errors.Wrap(err, "could not map db section")
+	}
+
+	if err = config.Section("log").MapTo(next.Log); err != nil {
+		return errors.Wrap(err, "could not map log section")
+	}
+
+	if err = config.Section("patients").MapTo(next.Patients); err != nil {
+		return errors.Wrap(err, "could not map patients section")
 	}
-	if err := os.MkdirAll(General.Root, os.ModePerm); err != nil {
+
+	if err = config.Section("http").MapTo(next.HTTP); err != nil {
+		return errors.Wrap(err, "could not map http section")
+	}
+
+	if err = config.Section("alert").MapTo(next.Alert); err != nil {
+		return errors.Wrap(err, "could not map alert section")
+	}
+
+	if err = config.Section("webhook").MapTo(next.Webhook); err != nil {
+		return errors.Wrap(err, "could not map webhook section")
+	}
+
+	if err = next.mapJobs(config); err != nil {
+		return errors.Wrap(err, "could not map job sections")
+	}
+
+	if err = next.mapChannels(config); err != nil {
+		return errors.Wrap(err, "could not map channel sections")
+	}
+
+	if err = next.mapTxtRules(config); err != nil {
+		return errors.Wrap(err, "could not map txt sections")
+	}
+
+	if next.General.Templates != "" && !filepath.IsAbs(next.General.Templates) {
+		next.General.Templates = path.Join(AppWorkPath, next.General.Templates)
+	}
+	if next.DB.File != "" && !filepath.IsAbs(next.DB.File) {
+		next.DB.File = path.Join(AppWorkPath, next.DB.File)
+	}
+
+	if err = validate(config, next); err != nil {
+		return err
+	}
+
+	if !filepath.IsAbs(next.General.Root) {
+		next.General.Root = path.Join(AppWorkPath, next.General.Root)
+	}
+	if err := os.MkdirAll(next.General.Root, os.ModePerm); err != nil {
 		return errors.Wrap(err, "could not create folders of root path")
 	}
 
+	// replace the active configuration only if the new one is valid
+	next.Settings = effectiveSettings(config, next, sources)
+	active.Store(next)
+
+	return nil
+}
+
+// validate checks the mapped configuration and reports all problems at once
+// it also parses the schedule and resolves secrets
+func validate(config *ini.File, next *Snapshot) error {
+	problems := &ValidationError{}
+
+	checkKeys(config, next, problems)
+
+	// general
+	checkRequired(problems, "general", "ROOT", next.General.Root)
+
+	if next.General.MinGap < 0 {
+		problems.add("general", "MIN_GAP", "must not be negative")
+	}
+	if next.General.TimeZone != "" {
+		checkTimeZone(problems, "general", "TIMEZONE", next.General.TimeZone)
+	}
+	if next.General.Templates != "" {
+		if info, err := os.Stat(next.General.Templates); err != nil || !info.IsDir() {
+			problems.add("general", "TEMPLATES", "directory %q not found", next.General.Templates)
+		}
+	}
+
+	// jobs
 	parser := cron.NewParser(cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
-	General.Schedule, err = parser.Parse(General.CronExpression)
-	if err != nil {
-		return errors.Wrap(err, "could not parse cron expression")
+	validateJobs(next, parser, problems)
+	validateChannels(next, problems)
+
+	// mail
+	checkRequired(problems, "mail", "SERVER", next.Mail.Server)
+	checkPort(problems, "mail", "PORT", next.Mail.Port, false)
+	checkAddress(problems, "mail", "FROM", next.Mail.From)
+	checkAddressList(problems, "mail", "TO", next.Mail.To)
+	if next.Mail.RateLimit < 0 {
+		problems.add("mail", "RATE_LIMIT", "must not be negative")
 	}
-	// calculate interval
-	nextExecutionTime := General.Schedule.Next(time.Now())
-	General.Interval = General.Schedule.Next(nextExecutionTime).Sub(nextExecutionTime)
 
-	// check if interval is longer than 15 minutes
+	// db
+	validateDB(next.DB, problems)
 
-	if General.Interval.Minutes() < 15 {
-		return errors.New("schedule interval shorter than 15 minutes")
+	checkRequired(problems, "db", "TIMEZONE", next.DB.TimeZone)
+	if next.DB.TimeZone != "" {
+		checkTimeZone(problems, "db", "TIMEZONE", next.DB.TimeZone)
+	}
+	for _, format := range next.DB.TimeFormatList() {
+		if !isClockLayout(format) {
+			problems.add("db", "TIME_FORMATS", "layout %q does not contain hour and minute, e.g. 15:04", format)
+		}
 	}
 
-	if err = config.Section("mail").MapTo(Mail); err != nil {
-		return errors.Wrap(err, "could not map mail section")
+	// txt
+	validateTxtRules(next, problems)
+
+	// patients
+	validatePatients(next, problems)
+
+	// alert
+	if next.Alert.To != "" {
+		checkAddressList(problems, "alert", "TO", next.Alert.To)
+		if next.Alert.After < 1 {
+			problems.add("alert", "AFTER", "must be at least 1")
+		}
+		if next.Alert.Server != "" {
+			checkPort(problems, "alert", "PORT", next.Alert.Port, false)
+		}
+		if next.Alert.From != "" {
+			checkAddress(problems, "alert", "FROM", next.Alert.From)
+		}
 	}
 
-	if err = config.Section("db").MapTo(DB); err != nil {
-		return errors.Wrap(err, "could not map db section")
+	// http
+	if next.HTTP.Listen != "" {
+		checkListen(problems, "http", "LISTEN", next.HTTP.Listen)
 	}
 
-	if err = config.Section("log").MapTo(Log); err != nil {
-		return errors.Wrap(err, "could not map log section")
+	// webhook
+	validateWebhook(next, problems)
+
+	// log
+	if _, err := zerolog.ParseLevel(next.Log.Level); err != nil {
+		problems.add("log", "LEVEL", "invalid log level %q", next.Log.Level)
 	}
 
-	if _, err := zerolog.ParseLevel(Log.Level); err != nil {
-		return errors.Wrap(err, "could not parse log level")
+	resolveSecrets(next, problems)
+
+	// the secret is known once its reference is resolved
+	if next.DB.Type == DBTypeWebhook && !problems.has("webhook", "SECRET") && len(next.Webhook.Secret) < minWebhookSecret {
+		problems.add("webhook", "SECRET", "must be at least %d characters", minWebhookSecret)
 	}
 
-	return nil
+	return problems.orNil()
+}
+
+// secretValue is a config value which may reference a secret
+type secretValue struct {
+	section string
+	key     string
+	value   *string
+}
+
+// resolveSecrets replaces secret references (env:, file:, keyring:) by their values
+func resolveSecrets(next *Snapshot, problems *ValidationError) {
+	secrets := []secretValue{
+		{"mail", "PASSWORD", &next.Mail.Password},
+		{"db", "PASSWORD", &next.DB.Password},
+		{"db", "DSN", &next.DB.DSN},
+		{"alert", "PASSWORD", &next.Alert.Password},
+		{"webhook", "SECRET", &next.Webhook.Secret},
+	}
+	for _, c := range next.Channels {
+		section := ChannelSectionPrefix + c.Name
+		secrets = append(secrets, secretValue{section, "URL", &c.URL}, secretValue{section, "TOKEN", &c.Token})
+	}
+
+	for _, s := range secrets {
+		resolved, err := secret.Resolve(*s.value)
+		if err != nil {
+			problems.add(s.section, s.key, "could not resolve secret: %v", errors.Cause(err))
+			continue
+		}
+		*s.value = resolved
+	}
 }
 
 func getAppPath() (string, error) {
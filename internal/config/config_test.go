@@ -0,0 +1,89 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad_Reload(t *testing.T) {
+	dir := t.TempDir()
+	Path = filepath.Join(dir, "app.ini")
+	write := func(minGap string) {
+		content := "[general]\nROOT = " + filepath.Join(dir, "data") + "\nSCHEDULE = 0 0 6 * * *\nMIN_GAP = " + minGap + "\n" + validMailDB
+		if err := os.WriteFile(Path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("15m")
+	if err := Load(); err != nil {
+		t.Fatal(err)
+	}
+	first := Current()
+
+	// readers keep the snapshot they took while the configuration is reloaded
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				cfg := Current()
+				_ = cfg.General.MinGap
+				_, _ = cfg.FindJob(DefaultJobName)
+			}
+		}()
+	}
+
+	write("30m")
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, Load())
+	}
+	close(stop)
+	wg.Wait()
+
+	assert.Equal(t, "15m0s", first.General.MinGap.String())
+	assert.Equal(t, "30m0s", Current().General.MinGap.String())
+
+	// an invalid configuration keeps the active one
+	if err := os.WriteFile(Path, []byte("[general]\nROOT =\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	assert.Error(t, Load())
+	assert.Equal(t, "30m0s", Current().General.MinGap.String())
+}
+
+func TestLoad_RelativeDBFile(t *testing.T) {
+	dir := t.TempDir()
+	Path = filepath.Join(dir, "app.ini")
+	content := "[general]\nROOT = " + filepath.Join(dir, "data") + "\nSCHEDULE = 0 0 6 * * *\n" + `
+[mail]
+SERVER = smtp.example.com
+PORT   = 587
+FROM   = mailer@example.com
+TO     = empfang@example.com
+
+[db]
+TYPE = sqlite
+FILE = db/emed.sqlite
+`
+	if err := os.WriteFile(Path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the database file is resolved against the work path like ROOT, not the current directory
+	assert.Equal(t, filepath.Join(AppWorkPath, "db", "emed.sqlite"), Current().DB.File)
+}
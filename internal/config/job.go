@@ -0,0 +1,231 @@
+package config
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/emed-appts/emed-mailer/internal/template"
+
+	"github.com/robfig/cron/v3"
+	"gopkg.in/ini.v1"
+)
+
+// JobSectionPrefix starts the names of job sections, e.g. [job.daily]
+const JobSectionPrefix = "job."
+
+// DefaultJobName is the name of the job defined by [general] SCHEDULE
+// if no job sections are configured
+const DefaultJobName = "changedappts"
+
+// job types
+const (
+	TypeChanges  = "changes"
+	TypeReport   = "report"
+	TypePatients = "patients"
+)
+
+// built-in templates of the job types
+const (
+	DefaultTemplate       = "changedappts.tmpl"
+	DefaultReportTemplate = "statistics.tmpl"
+)
+
+// default templates of the job types
+// patient notifications use a template per kind of notification
+var defaultTemplates = map[string]string{
+	TypeChanges:  DefaultTemplate,
+	TypeReport:   DefaultReportTemplate,
+	TypePatients: "",
+}
+
+// filters of collected changes
+const (
+	FilterAll           = "all"
+	FilterBookings      = "bookings"
+	FilterCancellations = "cancellations"
+)
+
+// job names are used as file names, so they are restricted
+var jobNamePattern = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// job defines the configuration of a job, read from a section [job.<name>]
+type job struct {
+	Name           string        `ini:"-"`
+	Type           string        `ini:"TYPE"`
+	CronExpression string        `ini:"SCHEDULE"`
+	Schedule       cron.Schedule `ini:"-"`
+	MinGap         time.Duration `ini:"MIN_GAP"`
+
+	Filter   string `ini:"FILTER"`
+	Template string `ini:"TEMPLATE"`
+
+	Channels  string `ini:"CHANNELS"`
+	To        string `ini:"TO"`
+	Subject   string `ini:"SUBJECT"`
+	SendEmpty bool   `ini:"SEND_EMPTY"`
+
+	UpcomingDays int  `ini:"UPCOMING_DAYS"`
+	Enrich       bool `ini:"ENRICH"`
+
+	ReminderBefore time.Duration `ini:"REMINDER_BEFORE"`
+}
+
+func newJob(name string) *job {
+	return &job{
+		Name:      name,
+		Type:      TypeChanges,
+		Filter:    FilterAll,
+		Channels:  MailChannel,
+		SendEmpty: true,
+
+		ReminderBefore: 24 * time.Hour,
+	}
+}
+
+// FindJob returns the configuration of job `name`
+func (s *Snapshot) FindJob(name string) (*job, bool) {
+	for _, j := range s.Jobs {
+		if j.Name == name {
+			return j, true
+		}
+	}
+	return nil, false
+}
+
+// UsesPatients reports if the job looks up patients in [patients]
+func (j *job) UsesPatients() bool {
+	return j.Type == TypePatients || j.Enrich
+}
+
+// ChannelNames returns the names of the channels the job notifies
+func (j *job) ChannelNames() []string {
+	var names []string
+	for _, name := range strings.Split(j.Channels, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// addJobSections creates a job for every job section of the config file
+func (next *Snapshot) addJobSections(cfg *ini.File) {
+	for _, section := range cfg.Sections() {
+		if strings.HasPrefix(section.Name(), JobSectionPrefix) {
+			next.Jobs = append(next.Jobs, newJob(strings.TrimPrefix(section.Name(), JobSectionPrefix)))
+		}
+	}
+}
+
+// mapJobs maps the job sections, jobs inherit MIN_GAP of [general] if not set
+// without job sections [general] SCHEDULE defines a single job
+func (next *Snapshot) mapJobs(cfg *ini.File) error {
+	if len(next.Jobs) == 0 && next.General.CronExpression != "" {
+		next.defaultJob = true
+
+		j := newJob(DefaultJobName)
+		j.CronExpression = next.General.CronExpression
+		j.MinGap = next.General.MinGap
+		j.Template = defaultTemplates[j.Type]
+		next.Jobs = append(next.Jobs, j)
+		return nil
+	}
+
+	for _, j := range next.Jobs {
+		section := cfg.Section(JobSectionPrefix + j.Name)
+		j.MinGap = next.General.MinGap
+		if err := section.MapTo(j); err != nil {
+			return err
+		}
+		if j.Template == "" {
+			j.Template = defaultTemplates[j.Type]
+		}
+	}
+	return nil
+}
+
+// validateJobs checks the job configuration and parses the schedules
+func validateJobs(next *Snapshot, parser cron.Parser, problems *ValidationError) {
+	hasSections := len(next.Jobs) > 0 && !next.defaultJob
+	if hasSections && next.General.CronExpression != "" {
+		problems.add("general", "SCHEDULE", "not allowed if jobs are configured in [job.<name>] sections, set it in the job sections")
+	}
+	if len(next.Jobs) == 0 {
+		problems.add("general", "SCHEDULE", "required if no jobs are configured in [job.<name>] sections")
+	}
+
+	for _, j := range next.Jobs {
+		section := "general"
+		if hasSections {
+			section = JobSectionPrefix + j.Name
+
+			if !jobNamePattern.MatchString(j.Name) {
+				problems.add(section, "", "invalid job name %q, only lowercase letters, digits, - and _ are allowed", j.Name)
+			}
+		}
+
+		if _, ok := defaultTemplates[j.Type]; !ok {
+			problems.add(section, "TYPE", "unknown job type %q, expected %s, %s or %s", j.Type, TypeChanges, TypeReport, TypePatients)
+		}
+
+		if j.CronExpression == "" {
+			problems.add(section, "SCHEDULE", "required")
+		} else if schedule, err := parser.Parse(j.CronExpression); err != nil {
+			problems.add(section, "SCHEDULE", "invalid cron expression: %v", err)
+		} else {
+			j.Schedule = schedule
+		}
+		if j.MinGap < 0 {
+			problems.add(section, "MIN_GAP", "must not be negative")
+		} else if interval := shortestInterval(j.Schedule); j.MinGap > 0 && interval > 0 && interval < j.MinGap {
+			problems.add(section, "MIN_GAP", "%s is longer than the %s between two runs of SCHEDULE, runs within MIN_GAP would be skipped", j.MinGap, interval)
+		}
+		if j.UpcomingDays < 0 {
+			problems.add(section, "UPCOMING_DAYS", "must not be negative")
+		}
+		if j.ReminderBefore < 0 {
+			problems.add(section, "REMINDER_BEFORE", "must not be negative")
+		}
+		if j.Enrich && j.Type != TypeChanges {
+			problems.add(section, "ENRICH", "only supported by jobs of type %s", TypeChanges)
+		}
+
+		switch j.Filter {
+		case FilterAll, FilterBookings, FilterCancellations:
+		default:
+			problems.add(section, "FILTER", "unknown filter %q, expected %s, %s or %s", j.Filter, FilterAll, FilterBookings, FilterCancellations)
+		}
+
+		if j.Type == TypePatients && j.Template != "" {
+			problems.add(section, "TEMPLATE", "not supported by jobs of type %s, customize the patient_*.tmpl templates in [general] TEMPLATES", TypePatients)
+		} else if j.Template != "" && !template.Exists(next.General.Templates, j.Template) {
+			problems.add(section, "TEMPLATE", "template %q not found", j.Template)
+		}
+
+		if j.To != "" {
+			checkAddressList(problems, section, "TO", j.To)
+		}
+	}
+}
+
+// shortestInterval returns the shortest time between the next activations of `schedule`, 0 without a schedule
+func shortestInterval(schedule cron.Schedule) time.Duration {
+	if schedule == nil {
+		return 0
+	}
+
+	var shortest time.Duration
+	activation := schedule.Next(time.Now())
+	for i := 0; i < 16; i++ {
+		next := schedule.Next(activation)
+		if next.IsZero() {
+			break
+		}
+		if interval := next.Sub(activation); shortest == 0 || interval < shortest {
+			shortest = interval
+		}
+		activation = next
+	}
+	return shortest
+}
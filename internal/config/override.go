@@ -0,0 +1,205 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/emed-appts/emed-mailer/internal/secret"
+
+	"github.com/pkg/errors"
+	"gopkg.in/ini.v1"
+)
+
+// EnvPrefix is the prefix of environment variables overriding config keys
+// e.g. EMED_MAIL_SERVER overrides key SERVER of section mail
+const EnvPrefix = "EMED_"
+
+// sources of a config value
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+var (
+	// Overrides set by command line, formatted as section.KEY=value
+	Overrides []string
+
+	// secretKeys must never be logged in plaintext
+	secretKeys = map[string]bool{
+		"PASSWORD": true,
+		"DSN":      true,
+		"TOKEN":    true,
+		"SECRET":   true,
+		// urls of webhooks contain their credentials
+		"URL": true,
+	}
+)
+
+// Setting describes the effective value of a config key
+type Setting struct {
+	Section string
+	Key     string
+	Value   string
+	Source  string
+}
+
+// sectionTarget maps a config section to its struct
+type sectionTarget struct {
+	name   string
+	target interface{}
+}
+
+// sections returns all known config sections and their targets
+func sections(s *Snapshot) []sectionTarget {
+	targets := []sectionTarget{
+		{"general", s.General},
+		{"mail", s.Mail},
+		{"db", s.DB},
+		{"log", s.Log},
+		{"patients", s.Patients},
+		{"http", s.HTTP},
+		{"alert", s.Alert},
+		{"webhook", s.Webhook},
+	}
+	for _, j := range s.Jobs {
+		targets = append(targets, sectionTarget{JobSectionPrefix + j.Name, j})
+	}
+	for _, c := range s.Channels {
+		targets = append(targets, sectionTarget{ChannelSectionPrefix + c.Name, c})
+	}
+	for _, r := range s.TxtRules {
+		targets = append(targets, sectionTarget{TxtSectionPrefix + r.Name, r})
+	}
+	return targets
+}
+
+// applyOverrides sets values of environment variables and command line overrides
+// on the loaded ini file and returns the source of every key
+func applyOverrides(cfg *ini.File, next *Snapshot) (map[string]string, error) {
+	flags := map[string]string{}
+	for _, override := range Overrides {
+		kv := strings.SplitN(override, "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.Errorf("invalid override %q, expected section.KEY=value", override)
+		}
+		flags[strings.ToLower(kv[0])] = kv[1]
+	}
+
+	sources := map[string]string{}
+	for _, s := range sections(next) {
+		section := cfg.Section(s.name)
+
+		for _, key := range iniKeys(s.target) {
+			id := s.name + "." + key
+
+			source := SourceDefault
+			if section.HasKey(key) {
+				source = SourceFile
+			}
+			if value, ok := os.LookupEnv(envName(s.name, key)); ok {
+				section.Key(key).SetValue(value)
+				source = SourceEnv
+			}
+			if value, ok := flags[strings.ToLower(id)]; ok {
+				section.Key(key).SetValue(value)
+				source = SourceFlag
+				delete(flags, strings.ToLower(id))
+			}
+
+			sources[id] = source
+		}
+	}
+
+	for id := range flags {
+		return nil, errors.Errorf("unknown config key %q in override", id)
+	}
+
+	return sources, nil
+}
+
+// effectiveSettings lists the value and source of every key of the mapped configuration
+func effectiveSettings(cfg *ini.File, next *Snapshot, sources map[string]string) []Setting {
+	var settings []Setting
+	for _, s := range sections(next) {
+		section := cfg.Section(s.name)
+
+		for _, key := range iniKeys(s.target) {
+			value := defaultValue(s.target, key)
+			if section.HasKey(key) {
+				value = section.Key(key).String()
+			}
+
+			settings = append(settings, Setting{
+				Section: s.name,
+				Key:     key,
+				Value:   redact(key, value),
+				Source:  sources[s.name+"."+key],
+			})
+		}
+	}
+
+	return settings
+}
+
+// envName returns the name of the environment variable overriding a key
+// characters not allowed in names of environment variables are replaced by "_"
+// e.g. key SCHEDULE of section job.daily is overridden by EMED_JOB_DAILY_SCHEDULE
+func envName(section, key string) string {
+	name := EnvPrefix + strings.ToUpper(section) + "_" + strings.ToUpper(key)
+	return strings.Map(func(r rune) rune {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// iniKeys returns the ini keys of a config struct in declaration order
+func iniKeys(target interface{}) []string {
+	var keys []string
+
+	t := reflect.TypeOf(target).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		key := t.Field(i).Tag.Get("ini")
+		if key == "" || key == "-" {
+			continue
+		}
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// iniFields returns the struct fields of a config struct by ini key
+func iniFields(target interface{}) map[string]reflect.StructField {
+	fields := map[string]reflect.StructField{}
+
+	t := reflect.TypeOf(target).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		key := t.Field(i).Tag.Get("ini")
+		if key == "" || key == "-" {
+			continue
+		}
+		fields[key] = t.Field(i)
+	}
+
+	return fields
+}
+
+// defaultValue returns the value of a config struct field by ini key
+func defaultValue(target interface{}, key string) string {
+	field := iniFields(target)[key]
+	return fmt.Sprint(reflect.ValueOf(target).Elem().FieldByIndex(field.Index).Interface())
+}
+
+// redact hides secret values, references to secrets are kept
+func redact(key, value string) string {
+	if !secretKeys[key] || value == "" || secret.IsReference(value) {
+		return value
+	}
+	return "********"
+}
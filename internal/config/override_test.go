@@ -0,0 +1,68 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ini.v1"
+)
+
+func TestApplyOverrides(t *testing.T) {
+	cfg, err := ini.Load([]byte("[mail]\nSERVER = file.example.com\nPORT = 25\nPASSWORD = secret\n[db]\nPASSWORD = env:DB_PASSWORD\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("EMED_MAIL_SERVER", "env.example.com")
+	t.Setenv("EMED_MAIL_PORT", "587")
+	Overrides = []string{"mail.PORT=465"}
+	defer func() { Overrides = nil }()
+
+	next := newSnapshot()
+	sources, err := applyOverrides(cfg, next)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "env.example.com", cfg.Section("mail").Key("SERVER").String())
+	assert.Equal(t, "465", cfg.Section("mail").Key("PORT").String())
+
+	assert.Equal(t, SourceEnv, sources["mail.SERVER"])
+	assert.Equal(t, SourceFlag, sources["mail.PORT"])
+	assert.Equal(t, SourceDefault, sources["mail.USER"])
+	assert.Equal(t, SourceFile, sources["mail.PASSWORD"])
+
+	byKey := map[string]Setting{}
+	for _, s := range effectiveSettings(cfg, next, sources) {
+		byKey[s.Section+"."+s.Key] = s
+	}
+	assert.Equal(t, SourceEnv, byKey["mail.SERVER"].Source)
+	assert.Equal(t, "********", byKey["mail.PASSWORD"].Value)
+	assert.Equal(t, "env:DB_PASSWORD", byKey["db.PASSWORD"].Value)
+	assert.Equal(t, "0s", byKey["general.MIN_GAP"].Value)
+}
+
+func TestApplyOverrides_Job(t *testing.T) {
+	cfg, err := ini.Load([]byte("[job.daily-summary]\nSCHEDULE = 0 0 6 * * *\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("EMED_JOB_DAILY_SUMMARY_FILTER", "bookings")
+	Overrides = []string{"job.daily-summary.SEND_EMPTY=false"}
+	defer func() { Overrides = nil }()
+
+	next := newSnapshot()
+	next.addJobSections(cfg)
+	_, err = applyOverrides(cfg, next)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "bookings", cfg.Section("job.daily-summary").Key("FILTER").String())
+	assert.Equal(t, "false", cfg.Section("job.daily-summary").Key("SEND_EMPTY").String())
+}
+
+func TestApplyOverrides_Unknown(t *testing.T) {
+	Overrides = []string{"mail.UNKNOWN=1"}
+	defer func() { Overrides = nil }()
+
+	_, err := applyOverrides(ini.Empty(), newSnapshot())
+	assert.Error(t, err)
+}
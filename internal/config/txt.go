@@ -0,0 +1,93 @@
+package config
+
+import (
+	"regexp"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// TxtSectionPrefix starts the names of the sections of txt rules, e.g. [txt.etermin]
+const TxtSectionPrefix = "txt."
+
+// TxtFields are the named groups a txt rule extracts
+var TxtFields = []string{"surname", "firstname", "birthdate", "phone", "comment"}
+
+// txtRule extracts structured fields from the txt column of the appointment log,
+// read from a section [txt.<name>]
+type txtRule struct {
+	Name    string `ini:"-"`
+	Pattern string `ini:"PATTERN"`
+	// BirthDateFormat is the layout of the birthdate group
+	BirthDateFormat string `ini:"BIRTHDATE_FORMAT"`
+
+	// Regexp is the compiled PATTERN, set by validation
+	Regexp *regexp.Regexp `ini:"-"`
+}
+
+func newTxtRule(name string) *txtRule {
+	return &txtRule{
+		Name:            name,
+		BirthDateFormat: "02.01.2006",
+	}
+}
+
+// addTxtSections creates a txt rule for every txt section of the config file
+func (next *Snapshot) addTxtSections(cfg *ini.File) {
+	for _, section := range cfg.Sections() {
+		if strings.HasPrefix(section.Name(), TxtSectionPrefix) {
+			next.TxtRules = append(next.TxtRules, newTxtRule(strings.TrimPrefix(section.Name(), TxtSectionPrefix)))
+		}
+	}
+}
+
+// mapTxtRules maps the txt sections
+func (next *Snapshot) mapTxtRules(cfg *ini.File) error {
+	for _, r := range next.TxtRules {
+		if err := cfg.Section(TxtSectionPrefix + r.Name).MapTo(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateTxtRules compiles the patterns and checks their named groups
+func validateTxtRules(next *Snapshot, problems *ValidationError) {
+	known := map[string]bool{}
+	for _, field := range TxtFields {
+		known[field] = true
+	}
+
+	for _, r := range next.TxtRules {
+		section := TxtSectionPrefix + r.Name
+
+		if r.Pattern == "" {
+			problems.add(section, "PATTERN", "required")
+			continue
+		}
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			problems.add(section, "PATTERN", "invalid regular expression: %v", err)
+			continue
+		}
+
+		groups := 0
+		for _, name := range re.SubexpNames() {
+			if name == "" {
+				continue
+			}
+			groups++
+			if !known[name] {
+				problems.add(section, "PATTERN", "unknown group %q, expected %s", name, strings.Join(TxtFields, ", "))
+			}
+		}
+		if groups == 0 {
+			problems.add(section, "PATTERN", "no named group, e.g. (?P<surname>[^,]+)")
+		}
+		r.Regexp = re
+
+		if !isDateLayout(r.BirthDateFormat) {
+			problems.add(section, "BIRTHDATE_FORMAT", "layout %q does not contain year, month and day, e.g. 02.01.2006", r.BirthDateFormat)
+		}
+	}
+}
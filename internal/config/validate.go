@@ -0,0 +1,320 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	netmail "net/mail"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emed-appts/emed-mailer/internal/collector/tzinfo"
+
+	"gopkg.in/ini.v1"
+)
+
+// Problem describes a single invalid config key
+type Problem struct {
+	Section string
+	Key     string
+	Reason  string
+}
+
+func (p Problem) String() string {
+	if p.Key == "" {
+		return fmt.Sprintf("[%s]: %s", p.Section, p.Reason)
+	}
+	return fmt.Sprintf("[%s] %s: %s", p.Section, p.Key, p.Reason)
+}
+
+// ValidationError aggregates all problems found in a config file
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (err *ValidationError) Error() string {
+	lines := make([]string, 0, len(err.Problems)+1)
+	lines = append(lines, fmt.Sprintf("configuration has %d problem(s):", len(err.Problems)))
+	for _, p := range err.Problems {
+		lines = append(lines, "  "+p.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// add records a problem
+func (err *ValidationError) add(section, key, format string, args ...interface{}) {
+	err.Problems = append(err.Problems, Problem{
+		Section: section,
+		Key:     key,
+		Reason:  fmt.Sprintf(format, args...),
+	})
+}
+
+// has reports if a problem has been recorded for the key
+func (err *ValidationError) has(section, key string) bool {
+	for _, p := range err.Problems {
+		if p.Section == section && p.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// orNil returns nil if no problems have been recorded
+func (err *ValidationError) orNil() error {
+	if len(err.Problems) == 0 {
+		return nil
+	}
+	return err
+}
+
+// checkKeys reports unknown sections and keys as well as values not matching the key's type
+func checkKeys(cfg *ini.File, next *Snapshot, problems *ValidationError) {
+	known := map[string]interface{}{}
+	for _, s := range sections(next) {
+		known[s.name] = s.target
+	}
+
+	for _, section := range cfg.Sections() {
+		name := section.Name()
+		target, ok := known[name]
+		if !ok {
+			if name != ini.DefaultSection || len(section.Keys()) > 0 {
+				problems.add(name, "", "unknown section")
+			}
+			continue
+		}
+
+		fields := iniFields(target)
+		for _, key := range section.Keys() {
+			field, ok := fields[key.Name()]
+			if !ok {
+				problems.add(name, key.Name(), "unknown key")
+				continue
+			}
+			if key.String() == "" {
+				continue
+			}
+
+			var err error
+			kind := field.Type.Kind().String()
+			switch {
+			case field.Type == reflect.TypeOf(time.Duration(0)):
+				_, err = key.Duration()
+				kind = "duration"
+			case field.Type.Kind() == reflect.Int || field.Type.Kind() == reflect.Int64:
+				_, err = key.Int64()
+			case field.Type.Kind() == reflect.Bool:
+				_, err = key.Bool()
+			}
+			if err != nil {
+				problems.add(name, key.Name(), "invalid %s value %q", kind, key.String())
+			}
+		}
+	}
+}
+
+// checkRequired reports an empty value
+func checkRequired(problems *ValidationError, section, key, value string) {
+	if strings.TrimSpace(value) == "" {
+		problems.add(section, key, "required")
+	}
+}
+
+// checkPort reports ports out of range, 0 is allowed if the port is optional
+func checkPort(problems *ValidationError, section, key string, port int, optional bool) {
+	if problems.has(section, key) || (port == 0 && optional) {
+		return
+	}
+	if port < 1 || port > 65535 {
+		problems.add(section, key, "port %d out of range 1-65535", port)
+	}
+}
+
+// checkListen reports an invalid listen address of an http server
+func checkListen(problems *ValidationError, section, key, value string) {
+	if _, port, err := net.SplitHostPort(value); err != nil {
+		problems.add(section, key, "invalid address %q, expected host:port or :port", value)
+	} else if p, err := strconv.Atoi(port); err != nil || p < 0 || p > 65535 {
+		problems.add(section, key, "invalid port %q", port)
+	}
+}
+
+// checkAddress reports an invalid email address
+func checkAddress(problems *ValidationError, section, key, value string) {
+	if value == "" {
+		problems.add(section, key, "required")
+		return
+	}
+	if _, err := netmail.ParseAddress(value); err != nil {
+		problems.add(section, key, "invalid email address %q: %v", value, err)
+	}
+}
+
+// checkAddressList reports an invalid comma separated list of email addresses
+func checkAddressList(problems *ValidationError, section, key, value string) {
+	if value == "" {
+		problems.add(section, key, "required")
+		return
+	}
+	if _, err := netmail.ParseAddressList(value); err != nil {
+		problems.add(section, key, "invalid email address list %q: %v", value, err)
+	}
+}
+
+// identifiers of tables and columns are inserted into queries, so they are restricted
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)*$`)
+
+// checkIdentifier reports invalid names of tables and columns
+func checkIdentifier(problems *ValidationError, section, key, value string, required bool) {
+	if value == "" {
+		if required {
+			problems.add(section, key, "required")
+		}
+		return
+	}
+	if !identifierPattern.MatchString(value) {
+		problems.add(section, key, "invalid identifier %q, only letters, digits, _ and . are allowed", value)
+	}
+}
+
+// checkTimeZone reports unknown time zones, e.g. Europe/Vienna
+func checkTimeZone(problems *ValidationError, section, key, value string) {
+	if _, err := tzinfo.LoadLocation(value); err != nil {
+		problems.add(section, key, "unknown time zone %q, expected e.g. %s", value, DefaultTimeZone)
+	}
+}
+
+// isClockLayout reports if the time layout `layout` keeps hour and minute
+func isClockLayout(layout string) bool {
+	ref := time.Date(2000, 1, 1, 13, 47, 0, 0, time.UTC)
+	t, err := time.Parse(layout, ref.Format(layout))
+	return err == nil && t.Hour() == ref.Hour() && t.Minute() == ref.Minute()
+}
+
+// isDateLayout reports if the time layout `layout` keeps year, month and day
+func isDateLayout(layout string) bool {
+	ref := time.Date(1987, 11, 23, 0, 0, 0, 0, time.UTC)
+	t, err := time.Parse(layout, ref.Format(layout))
+	return err == nil && t.Equal(ref)
+}
+
+// validateDB checks the connection settings of the database type
+func validateDB(d *db, problems *ValidationError) {
+	switch d.Type {
+	case DBTypeMSSQL:
+		if d.DSN != "" {
+			return
+		}
+		checkRequired(problems, "db", "SERVER", d.Server)
+		checkPort(problems, "db", "PORT", d.Port, true)
+		checkRequired(problems, "db", "DATABASE", d.Database)
+
+		switch strings.ToLower(d.Auth) {
+		case "", "sql":
+			checkRequired(problems, "db", "USER", d.User)
+		case "ntlm":
+			checkRequired(problems, "db", "USER", d.User)
+			if d.Domain == "" && !strings.Contains(d.User, `\`) {
+				problems.add("db", "DOMAIN", "required for ntlm authentication")
+			}
+		case "windows":
+		default:
+			problems.add("db", "AUTH", "unknown authentication mode %q, expected sql, windows or ntlm", d.Auth)
+		}
+	case DBTypePostgres, DBTypeMySQL:
+		if d.DSN != "" {
+			return
+		}
+		checkRequired(problems, "db", "SERVER", d.Server)
+		checkPort(problems, "db", "PORT", d.Port, true)
+		checkRequired(problems, "db", "DATABASE", d.Database)
+		checkRequired(problems, "db", "USER", d.User)
+		if d.Auth != "" && !strings.EqualFold(d.Auth, "sql") {
+			problems.add("db", "AUTH", "only sql authentication is supported by %s", d.Type)
+		}
+		if d.Instance != "" {
+			problems.add("db", "INSTANCE", "only supported by %s", DBTypeMSSQL)
+		}
+	case DBTypeSQLite:
+		if d.DSN == "" {
+			checkRequired(problems, "db", "FILE", d.File)
+		}
+	case DBTypeCSV:
+		checkRequired(problems, "db", "FILE", d.File)
+		if d.DSN != "" {
+			problems.add("db", "DSN", "not supported by %s, set FILE", DBTypeCSV)
+		}
+	case DBTypeWebhook:
+		// the events are stored below [general] ROOT
+		if d.DSN != "" {
+			problems.add("db", "DSN", "not supported by %s, see [webhook]", DBTypeWebhook)
+		}
+		if d.File != "" {
+			problems.add("db", "FILE", "not supported by %s, see [webhook]", DBTypeWebhook)
+		}
+	default:
+		problems.add("db", "TYPE", "unknown database type %q, expected %s, %s, %s, %s, %s or %s",
+			d.Type, DBTypeMSSQL, DBTypePostgres, DBTypeMySQL, DBTypeSQLite, DBTypeCSV, DBTypeWebhook)
+	}
+}
+
+// minWebhookSecret is the minimum length of the secret signing webhook requests
+const minWebhookSecret = 16
+
+// validateWebhook checks the endpoint receiving events if the events are the source of appointments
+func validateWebhook(next *Snapshot, problems *ValidationError) {
+	if next.DB.Type != DBTypeWebhook {
+		return
+	}
+
+	w := next.Webhook
+	checkRequired(problems, "webhook", "LISTEN", w.Listen)
+	if w.Listen != "" {
+		checkListen(problems, "webhook", "LISTEN", w.Listen)
+		if w.Listen == next.HTTP.Listen {
+			problems.add("webhook", "LISTEN", "must differ from [http] LISTEN")
+		}
+	}
+	checkRequired(problems, "webhook", "SECRET", w.Secret)
+	if w.Tolerance <= 0 {
+		problems.add("webhook", "TOLERANCE", "must be positive")
+	}
+	if w.Retention < 24*time.Hour {
+		problems.add("webhook", "RETENTION", "must be at least 24h")
+	}
+}
+
+// validatePatients checks the lookup of patients if a job uses it
+// the mail address is only required by jobs of type patients
+func validatePatients(next *Snapshot, problems *ValidationError) {
+	var used, notify, enrich bool
+	for _, j := range next.Jobs {
+		used = used || j.UsesPatients()
+		notify = notify || j.Type == TypePatients
+		enrich = enrich || j.Enrich
+	}
+	if !used {
+		return
+	}
+
+	if next.DB.Type == DBTypeCSV || next.DB.Type == DBTypeWebhook {
+		problems.add("patients", "", "requires a database, not available with [db] TYPE %s", next.DB.Type)
+		return
+	}
+
+	p := next.Patients
+	checkIdentifier(problems, "patients", "TABLE", p.Table, true)
+	checkIdentifier(problems, "patients", "ID_COLUMN", p.IDColumn, true)
+	checkIdentifier(problems, "patients", "EMAIL_COLUMN", p.EmailColumn, notify)
+	checkIdentifier(problems, "patients", "OPT_OUT_COLUMN", p.OptOutColumn, false)
+	checkIdentifier(problems, "patients", "PHONE_COLUMN", p.PhoneColumn, false)
+	checkIdentifier(problems, "patients", "INSURANCE_COLUMN", p.InsuranceColumn, false)
+	checkIdentifier(problems, "patients", "NEW_PATIENT_COLUMN", p.NewPatientColumn, false)
+
+	if enrich && p.PhoneColumn == "" && p.InsuranceColumn == "" && p.NewPatientColumn == "" {
+		problems.add("patients", "", "jobs with ENRICH require PHONE_COLUMN, INSURANCE_COLUMN or NEW_PATIENT_COLUMN")
+	}
+}
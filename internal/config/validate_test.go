@@ -0,0 +1,323 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ini.v1"
+)
+
+// validateProblems maps and validates `source` like Load and returns the keys of all problems
+func validateProblems(t *testing.T, source string) []string {
+	cfg, err := ini.Load([]byte(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next := newSnapshot()
+	next.addJobSections(cfg)
+	next.addChannelSections(cfg)
+	next.addTxtSections(cfg)
+	for _, s := range sections(next) {
+		if err := cfg.Section(s.name).MapTo(s.target); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := next.mapJobs(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := next.mapChannels(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := next.mapTxtRules(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	err = validate(cfg, next)
+	if err == nil {
+		return nil
+	}
+	if !assert.IsType(t, &ValidationError{}, err) {
+		return nil
+	}
+
+	var keys []string
+	for _, p := range err.(*ValidationError).Problems {
+		keys = append(keys, p.Section+"."+p.Key)
+	}
+	return keys
+}
+
+const validMailDB = `
+[mail]
+SERVER = smtp.example.com
+PORT   = 587
+FROM   = mailer@example.com
+TO     = empfang@example.com
+
+[db]
+SERVER   = db
+USER     = mailer
+DATABASE = emed
+
+[log]
+LEVEL = info
+`
+
+func TestValidate(t *testing.T) {
+	problems := validateProblems(t, `
+[general]
+ROOT     = data/
+SCHEDULE = 0 0 6 * * *
+UNKNOWN  = 1
+
+[mail]
+SERVER = smtp.example.com
+PORT   = abc
+FROM   = no address
+TO     = empfang@example.com, Arzt <arzt@example.com>
+
+[db]
+SERVER   = db
+AUTH     = ntlm
+USER     = mailer
+DATABASE = emed
+
+[log]
+LEVEL = info
+`)
+
+	assert.ElementsMatch(t, []string{"general.UNKNOWN", "mail.PORT", "mail.FROM", "db.DOMAIN"}, problems)
+}
+
+func TestValidate_TimeFormats(t *testing.T) {
+	problems := validateProblems(t, `
+[general]
+ROOT     = data/
+SCHEDULE = 0 0 6 * * *
+
+[mail]
+SERVER = smtp.example.com
+PORT   = 587
+FROM   = mailer@example.com
+TO     = empfang@example.com
+
+[db]
+SERVER       = db
+USER         = mailer
+DATABASE     = emed
+TIME_FORMATS = 15:04, 9.4, 2006-01-02
+`)
+
+	// layouts without hour and minute can not be interpreted
+	assert.ElementsMatch(t, []string{"db.TIME_FORMATS", "db.TIME_FORMATS"}, problems)
+}
+
+func TestValidate_TimeZone(t *testing.T) {
+	problems := validateProblems(t, `
+[general]
+ROOT     = data/
+SCHEDULE = 0 0 6 * * *
+TIMEZONE = Europe/Wien
+
+[mail]
+SERVER = smtp.example.com
+PORT   = 587
+FROM   = mailer@example.com
+TO     = empfang@example.com
+
+[db]
+SERVER   = db
+USER     = mailer
+DATABASE = emed
+TIMEZONE = Europe/Vienna
+`)
+
+	assert.ElementsMatch(t, []string{"general.TIMEZONE"}, problems)
+}
+
+func TestValidate_Jobs(t *testing.T) {
+	problems := validateProblems(t, `
+[general]
+ROOT     = data/
+SCHEDULE = 0 0 6 * * *
+
+[job.daily]
+SCHEDULE = 0 0 6 * * *
+FILTER   = everything
+
+[job.Weekly]
+SCHEDULE = 0 0 6 * * FRI
+TO       = broken
+
+[job.urgent]
+SCHEDULE = @every 5m
+MIN_GAP  = 15m
+`+validMailDB)
+
+	assert.ElementsMatch(t, []string{"general.SCHEDULE", "job.daily.FILTER", "job.Weekly.", "job.Weekly.TO", "job.urgent.MIN_GAP"}, problems)
+}
+
+func TestValidate_Channels(t *testing.T) {
+	problems := validateProblems(t, `
+[general]
+ROOT = data/
+
+[job.daily]
+SCHEDULE = 0 0 6 * * *
+CHANNELS = mail, sms, teams
+
+[job.urgent]
+SCHEDULE = @every 5m
+CHANNELS = pager
+
+[channel.sms]
+TYPE = sms
+URL  = https://sms.example.com/send
+
+[channel.teams]
+TYPE = webhook
+URL  = example.com/webhook
+`+validMailDB)
+
+	assert.ElementsMatch(t, []string{"channel.sms.TO", "channel.teams.URL", "job.urgent.CHANNELS"}, problems)
+}
+
+func TestValidate_DefaultJob(t *testing.T) {
+	problems := validateProblems(t, `
+[general]
+ROOT     = data/
+SCHEDULE = 0 0 6 * * *
+`+validMailDB)
+
+	assert.Empty(t, problems)
+}
+
+func TestValidate_TxtRules(t *testing.T) {
+	problems := validateProblems(t, `
+[general]
+ROOT     = data/
+SCHEDULE = 0 0 6 * * *
+
+[txt.full]
+PATTERN          = ^(?P<surname>[^,\s]+)\s+(?P<firstname>[^,]+),\s*(?P<birthdate>[\d.]+)
+BIRTHDATE_FORMAT = 2006-01-02
+
+[txt.broken]
+PATTERN = (?P<surname>[^,]+
+
+[txt.unknown]
+PATTERN          = ^(?P<name>[^,]+)
+BIRTHDATE_FORMAT = 15:04
+
+[txt.empty]
+`+validMailDB)
+
+	assert.ElementsMatch(t, []string{"txt.broken.PATTERN", "txt.unknown.PATTERN", "txt.unknown.BIRTHDATE_FORMAT", "txt.empty.PATTERN"}, problems)
+}
+
+func TestValidate_Patients(t *testing.T) {
+	problems := validateProblems(t, `
+[general]
+ROOT = data/
+
+[job.daily]
+SCHEDULE = 0 0 6 * * *
+ENRICH   = true
+
+[job.weekly]
+TYPE     = report
+SCHEDULE = 0 0 6 * * FRI
+ENRICH   = true
+
+[patients]
+TABLE     = dbo.Patient
+ID_COLUMN = PatNr
+`+validMailDB)
+
+	assert.ElementsMatch(t, []string{"job.weekly.ENRICH", "patients."}, problems)
+
+	// enrichment does not need the mail address
+	problems = validateProblems(t, `
+[general]
+ROOT = data/
+
+[job.daily]
+SCHEDULE = 0 0 6 * * *
+ENRICH   = true
+
+[patients]
+TABLE              = dbo.Patient
+ID_COLUMN          = PatNr
+PHONE_COLUMN       = Telefon
+NEW_PATIENT_COLUMN = Neu-Patient
+`+validMailDB)
+
+	assert.ElementsMatch(t, []string{"patients.NEW_PATIENT_COLUMN"}, problems)
+
+	// patient notifications use a template per kind of notification
+	problems = validateProblems(t, `
+[general]
+ROOT = data/
+
+[job.patients]
+TYPE     = patients
+SCHEDULE = @every 5m
+MIN_GAP  = 0
+TEMPLATE = changedappts.tmpl
+
+[patients]
+TABLE        = dbo.Patient
+ID_COLUMN    = PatNr
+EMAIL_COLUMN = Email
+`+validMailDB)
+
+	assert.ElementsMatch(t, []string{"job.patients.TEMPLATE"}, problems)
+}
+
+func TestValidate_DBTypes(t *testing.T) {
+	const mail = `
+[mail]
+SERVER = smtp.example.com
+PORT   = 587
+FROM   = mailer@example.com
+TO     = empfang@example.com
+`
+
+	tests := []struct {
+		name     string
+		db       string
+		problems []string
+	}{
+		{name: "postgres", db: "TYPE = postgres\nSERVER = db\nDATABASE = praxis\nAUTH = windows", problems: []string{"db.USER", "db.AUTH"}},
+		{name: "mysql dsn", db: "TYPE = mysql\nDSN = mailer@tcp(db)/praxis"},
+		{name: "sqlite", db: "TYPE = sqlite", problems: []string{"db.FILE"}},
+		{name: "csv", db: "TYPE = csv\nFILE = export/"},
+		{name: "webhook", db: "TYPE = webhook\n\n[webhook]\nLISTEN = :8443\nSECRET = 0123456789abcdef"},
+		{
+			name:     "webhook invalid",
+			db:       "TYPE = webhook\nFILE = export/\n\n[webhook]\nSECRET = short\nRETENTION = 1h",
+			problems: []string{"db.FILE", "webhook.LISTEN", "webhook.SECRET", "webhook.RETENTION"},
+		},
+		{name: "unknown", db: "TYPE = oracle", problems: []string{"db.TYPE"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems := validateProblems(t, "[general]\nROOT = data/\nSCHEDULE = 0 0 6 * * *\n"+mail+"\n[db]\n"+tt.db+"\n")
+			assert.ElementsMatch(t, tt.problems, problems)
+		})
+	}
+
+	// exported logs have no patient table
+	problems := validateProblems(t, "[general]\nROOT = data/\n"+mail+`
+[job.patients]
+TYPE     = patients
+SCHEDULE = @every 5m
+
+[db]
+TYPE = csv
+FILE = export/
+`)
+	assert.ElementsMatch(t, []string{"patients."}, problems)
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"os"
+	"time"
+)
+
+// Watch polls the config file at `Path` and notifies about changes
+// the returned channel is closed when `stop` is closed
+func Watch(interval time.Duration, stop <-chan struct{}) <-chan struct{} {
+	changes := make(chan struct{}, 1)
+
+	go func() {
+		defer close(changes)
+
+		last := fileVersion()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				current := fileVersion()
+				if current == last {
+					continue
+				}
+				last = current
+
+				// drop notification if the previous one has not been handled yet
+				select {
+				case changes <- struct{}{}:
+				default:
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return changes
+}
+
+// version identifies a state of the config file
+type version struct {
+	modTime time.Time
+	size    int64
+}
+
+func fileVersion() version {
+	info, err := os.Stat(Path)
+	if err != nil {
+		return version{}
+	}
+	return version{info.ModTime(), info.Size()}
+}
@@ -0,0 +1,186 @@
+package job
+
+import (
+	"sync"
+	"time"
+
+	"github.com/emed-appts/emed-mailer/internal/metrics"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Result of a run
+type Result string
+
+// results of a run
+const (
+	ResultSuccess Result = "success"
+	ResultSkipped Result = "skipped"
+	ResultFailed  Result = "failed"
+)
+
+// Status describes the latest run of a job
+type Status struct {
+	// LastRun is the time of the last successful run
+	LastRun time.Time
+	// LastAttempt is the time of the latest run, also if it failed or was skipped
+	LastAttempt time.Time
+	// Result of the latest run, empty before the first run
+	Result Result
+	// Count of the changes or notifications of the latest successful run
+	Count int
+	// Error of the latest failed run
+	Error string
+	// Failures counts the failed runs since the last successful run
+	Failures int
+	// FirstFailure is the time of the first failed run since the last successful run
+	FirstFailure time.Time
+}
+
+// base holds the state shared by all job types
+type base struct {
+	lastRun time.Time
+	cfg     Config
+
+	// prevents overlapping runs
+	mu sync.Mutex
+
+	// status is guarded separately, so it can be read during a run
+	status   Status
+	statusMu sync.RWMutex
+}
+
+// LastRun returns the time of the last successful run
+// it waits for a running execution to finish
+func (job *base) LastRun() time.Time {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	return job.lastRun
+}
+
+// Status returns the status of the latest run
+func (job *base) Status() Status {
+	job.statusMu.RLock()
+	defer job.statusMu.RUnlock()
+
+	return job.status
+}
+
+// Restore continues the status of a job it replaces
+// the failures keep counting, so alerts are sent once and recoveries are notified
+func (job *base) Restore(status Status) {
+	job.statusMu.Lock()
+	defer job.statusMu.Unlock()
+
+	job.status = status
+}
+
+// logger returns a logger tagged with the job name
+func (job *base) logger() zerolog.Logger {
+	return log.With().
+		Str("job", job.cfg.Name).
+		Logger()
+}
+
+// tooEarly reports if `run` follows the last run within the minimum gap
+func (job *base) tooEarly(run time.Time) bool {
+	gap := run.Sub(job.lastRun)
+	if gap >= job.cfg.MinGap {
+		return false
+	}
+
+	logger := job.logger()
+	logger.Warn().
+		Dur("gap", gap).
+		Dur("minGap", job.cfg.MinGap).
+		Msg("skip run, last run is too recent")
+
+	job.record(ResultSkipped, func(status *Status) {
+		status.LastAttempt = run
+	})
+
+	return true
+}
+
+// fail logs a failed run and records it in the status
+func (job *base) fail(run time.Time, err error) {
+	logger := job.logger()
+	logger.Error().
+		Err(err).
+		Msg("run failed")
+
+	var status Status
+	job.record(ResultFailed, func(s *Status) {
+		s.LastAttempt = run
+		s.Error = err.Error()
+		if s.Failures == 0 {
+			s.FirstFailure = run
+		}
+		s.Failures++
+		status = *s
+	})
+
+	// alert once when the failures reach the threshold
+	if job.cfg.Alerter != nil && status.Failures == job.cfg.AlertAfter {
+		job.alert(job.cfg.Alerter.Failed, run, status)
+	}
+}
+
+// alert sends an alert about the failures of `status`
+func (job *base) alert(send func(*Alert) error, run time.Time, status Status) {
+	err := send(&Alert{
+		Job:          job.cfg.Name,
+		Failures:     status.Failures,
+		Error:        status.Error,
+		FirstFailure: status.FirstFailure,
+		From:         job.lastRun,
+		To:           run,
+	})
+	if err != nil {
+		logger := job.logger()
+		logger.Error().
+			Err(err).
+			Msg("could not send alert")
+	}
+}
+
+// record updates the status with the result of a run and counts the run
+func (job *base) record(result Result, update func(*Status)) {
+	job.statusMu.Lock()
+	update(&job.status)
+	job.status.Result = result
+	job.statusMu.Unlock()
+
+	metrics.JobRuns.WithLabelValues(job.cfg.Name, string(result)).Inc()
+}
+
+// advance sets the lastRun time after a successful run of `count` changes and persists it
+func (job *base) advance(run time.Time, count int) {
+	var previous Status
+	job.record(ResultSuccess, func(status *Status) {
+		previous = *status
+		*status = Status{
+			LastRun:     run,
+			LastAttempt: run,
+			Count:       count,
+		}
+	})
+
+	// notify the recovery if the failures have been alerted
+	if job.cfg.Alerter != nil && job.cfg.AlertAfter > 0 && previous.Failures >= job.cfg.AlertAfter {
+		job.alert(job.cfg.Alerter.Recovered, run, previous)
+	}
+
+	job.lastRun = run
+
+	if job.cfg.Watermark != nil {
+		if err := job.cfg.Watermark.Save(run); err != nil {
+			logger := job.logger()
+			logger.Error().
+				Err(err).
+				Msg("could not save watermark")
+		}
+	}
+}
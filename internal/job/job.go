@@ -1,18 +1,43 @@
 package job
 
 import (
-	"bytes"
 	"time"
 
+	"github.com/emed-appts/emed-mailer/internal/config"
+	"github.com/emed-appts/emed-mailer/internal/metrics"
 	"github.com/emed-appts/emed-mailer/internal/template"
 
-	"github.com/rs/zerolog/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
-// Mailer interface
-type Mailer interface {
-	Run(<-chan struct{}) error
-	SendMessage(string, string) error
+// Type of a job
+type Type string
+
+// available job types
+const (
+	// TypeChanges reports every changed appointment
+	TypeChanges Type = config.TypeChanges
+	// TypeReport reports statistics of the changed appointments
+	TypeReport Type = config.TypeReport
+	// TypePatients notifies the patients about their appointments
+	TypePatients Type = config.TypePatients
+)
+
+// Message is a rendered notification
+type Message struct {
+	Subject string
+	// ContentType of the body, e.g. text/html
+	ContentType string
+	Body        string
+	// Text is a short plain text version for channels like SMS or chat
+	Text string
+}
+
+// Notifier interface
+type Notifier interface {
+	// sends a message over one or more channels
+	Notify(*Message) error
 }
 
 // ApptChange struct
@@ -22,72 +47,395 @@ type ApptChange struct {
 	PatientID   int
 	PatientName string
 	IsBooking   bool
+
+	// fields extracted from the log entry by the configured txt rules, empty if no rule matched
+	Surname   string
+	FirstName string
+	BirthDate time.Time
+	Phone     string
+	Comment   string
+
+	// Error describes why the log entry could not be interpreted, Appointment is zero then
+	Error string
+
+	// Patient holds the master data of the patient if the job enriches the changes,
+	// nil if the patient is unknown or enrichment is disabled
+	Patient *Patient
+}
+
+// UpcomingAppt struct
+type UpcomingAppt struct {
+	Appointment time.Time
+	BookedAt    time.Time
+	PatientID   int
+	PatientName string
+
+	// Patient holds the master data of the patient like ApptChange.Patient
+	Patient *Patient
+}
+
+// UpcomingDay groups the upcoming appointments of a day
+type UpcomingDay struct {
+	Day time.Time
+	// Offset counts the days from today, 0 is today
+	Offset int
+	Appts  []*UpcomingAppt
 }
 
 // Collector interface
 type Collector interface {
 	// collects latest changed appointments ordered by time of change
 	CollectChangedAppts(time.Time) ([]*ApptChange, error)
+	// collects booked appointments within a period ordered by appointment
+	CollectUpcomingAppts(time.Time, time.Time) ([]*UpcomingAppt, error)
+}
+
+// Watermark interface
+type Watermark interface {
+	// stores the time of the last successful run
+	Save(time.Time) error
+}
+
+// Alert describes the failures of a job
+type Alert struct {
+	Job string
+	// Failures counts the failed runs in a row
+	Failures int
+	// Error of the latest failure, including the chain of wrapped errors
+	Error string
+	// FirstFailure is the time of the first failed run in a row
+	FirstFailure time.Time
+	// From and To enclose the period whose changes have not been reported until the alert,
+	// From is the time of the last successful run
+	From time.Time
+	To   time.Time
+}
+
+// Alerter interface
+type Alerter interface {
+	// alerts that a job keeps failing
+	Failed(*Alert) error
+	// notifies that a job works again after an alert
+	Recovered(*Alert) error
 }
 
 // Job interface
 type Job interface {
 	Run()
+	// runs the job once for the changes within a period without changing the last run,
+	// returns the number of reported changes or notifications
+	RunWindow(time.Time, time.Time) (int, error)
+	// returns the time of the last successful run
+	LastRun() time.Time
+	// returns the status of the latest run
+	Status() Status
+	// continues the status of a job it replaces, e.g. on a reload
+	Restore(Status)
+}
+
+// Filter selects the changes reported by a job
+type Filter string
+
+// available filters
+const (
+	FilterAll           Filter = config.FilterAll
+	FilterBookings      Filter = config.FilterBookings
+	FilterCancellations Filter = config.FilterCancellations
+)
+
+// match reports if `change` passes the filter
+func (f Filter) match(change *ApptChange) bool {
+	switch f {
+	case FilterBookings:
+		return change.IsBooking
+	case FilterCancellations:
+		return !change.IsBooking
+	}
+	return true
+}
+
+// Config struct encapsulate all settings for a job
+type Config struct {
+	// Name identifies the job in logs
+	Name string
+	// Type selects the message, defaults to TypeChanges
+	Type Type
+	// MinGap is the minimum time between two runs, earlier runs are skipped
+	MinGap time.Duration
+	// Watermark persists the last run, optional
+	Watermark Watermark
+	// Alerter is told when the job failed AlertAfter times in a row and when it recovers, optional
+	Alerter    Alerter
+	AlertAfter int
+
+	// Filter selects the reported changes of TypeChanges, defaults to all
+	Filter Filter
+	// Template renders the message, defaults to the template of the type
+	Template string
+	// SendEmpty sends a message even if there are no changes
+	SendEmpty bool
+	// UpcomingDays adds the booked appointments of the next days to TypeChanges, 0 disables them
+	UpcomingDays int
+	// ReminderBefore reminds patients of TypePatients the duration before their appointment, 0 disables reminders
+	ReminderBefore time.Duration
+	// Directory enriches the changes and upcoming appointments of TypeChanges with the master data of the patients, optional
+	Directory PatientDirectory
+	// Location is the time zone of the days of upcoming appointments and statistics, defaults to the time zone of the run
+	Location *time.Location
 }
 
 type changedApptsJob struct {
+	base
 	collector Collector
-	mailer    Mailer
-	lastRun   time.Time
+	notifier  Notifier
 }
 
 // New creates a Job instance
-func New(collector Collector, mailer Mailer, lastRun time.Time) Job {
+// changes since `lastRun` are collected by the first run
+func New(collector Collector, notifier Notifier, lastRun time.Time, cfg Config) Job {
 	return &changedApptsJob{
+		base: base{
+			lastRun: lastRun,
+			cfg:     cfg,
+			status:  Status{LastRun: lastRun},
+		},
 		collector: collector,
-		mailer:    mailer,
-		lastRun:   lastRun,
+		notifier:  notifier,
 	}
 }
 
 // Run executes the job once
 func (job *changedApptsJob) Run() {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
 	// store execution time
 	run := time.Now()
 
-	changedAppts, err := job.collector.CollectChangedAppts(job.lastRun)
-	if err != nil {
-		log.Error().
-			Err(err).
-			Msg("collect updated appointments failed")
+	if job.tooEarly(run) {
+		return
+	}
 
+	count, err := job.process(job.lastRun, run)
+	if err != nil {
+		job.fail(run, err)
 		return
 	}
-	templateData := struct {
-		LastRun      time.Time
-		ChangedAppts []*ApptChange
-	}{
-		LastRun:      job.lastRun,
-		ChangedAppts: changedAppts,
+
+	job.advance(run, count)
+}
+
+// RunWindow executes the job once for the changes from `from` until `to`
+// the last run is not changed
+func (job *changedApptsJob) RunWindow(from, to time.Time) (int, error) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	return job.process(from, to)
+}
+
+// process sends the message about the changes from `from` until `to`
+// and returns the number of reported changes
+func (job *changedApptsJob) process(from, to time.Time) (int, error) {
+	logger := job.logger()
+
+	if job.cfg.Location != nil {
+		from, to = from.In(job.cfg.Location), to.In(job.cfg.Location)
 	}
 
-	buf := new(bytes.Buffer)
-	if err := template.Execute(buf, "changedappts.tmpl", templateData); err != nil {
-		log.Error().
-			Err(err).
-			Msg("could not execute template")
+	collected, err := job.collector.CollectChangedAppts(from)
+	if err != nil {
+		return 0, errors.Wrap(err, "collect updated appointments failed")
+	}
+	collected, unparsed := quarantined(until(collected, to))
+
+	var templateData interface{}
+	var count int
+	tmpl := job.cfg.Template
+
+	switch job.cfg.Type {
+	case TypeReport:
+		stats := NewStatistics(from, to, collected)
+		count = len(collected)
+
+		templateData = struct {
+			LastRun    time.Time
+			Statistics *Statistics
+			Unparsed   []*ApptChange
+		}{
+			LastRun:    from,
+			Statistics: stats,
+			Unparsed:   unparsed,
+		}
+		if tmpl == "" {
+			tmpl = config.DefaultReportTemplate
+		}
+
+	default:
+		var changedAppts []*ApptChange
+		for _, change := range collected {
+			if job.cfg.Filter.match(change) {
+				changedAppts = append(changedAppts, change)
+			}
+		}
+		count = len(changedAppts)
+
+		var upcoming []*UpcomingDay
+		if job.cfg.UpcomingDays > 0 {
+			today := truncateDay(to)
+			upcomingAppts, err := job.collector.CollectUpcomingAppts(to, today.AddDate(0, 0, job.cfg.UpcomingDays))
+			if err != nil {
+				return 0, errors.Wrap(err, "collect upcoming appointments failed")
+			}
+			upcoming = groupByDay(today, job.cfg.UpcomingDays, upcomingAppts)
+		}
+
+		if job.cfg.Directory != nil {
+			job.enrich(changedAppts, upcoming)
+		}
+
+		templateData = struct {
+			LastRun      time.Time
+			ChangedAppts []*ApptChange
+			Upcoming     []*UpcomingDay
+			Unparsed     []*ApptChange
+		}{
+			LastRun:      from,
+			ChangedAppts: changedAppts,
+			Upcoming:     upcoming,
+			Unparsed:     unparsed,
+		}
+		if tmpl == "" {
+			tmpl = config.DefaultTemplate
+		}
+	}
+
+	// entries which could not be interpreted are worth a message, they need a look
+	if count == 0 && len(unparsed) == 0 && !job.cfg.SendEmpty {
+		logger.Info().
+			Msg("no changes, skip sending empty message")
+
+		return 0, nil
+	}
+
+	timer := prometheus.NewTimer(metrics.RenderDuration.WithLabelValues(tmpl))
+	rendered, err := template.Render(tmpl, templateData)
+	timer.ObserveDuration()
+	if err != nil {
+		return 0, errors.Wrap(err, "could not execute template")
+	}
+
+	message := &Message{
+		Subject:     rendered.Subject,
+		ContentType: "text/html",
+		Body:        rendered.Body,
+		Text:        rendered.Text,
+	}
+	if message.Text == "" {
+		message.Text = message.Subject
+	}
+
+	if err := job.notifier.Notify(message); err != nil {
+		return 0, errors.Wrap(err, "could not send message")
+	}
+
+	return count, nil
+}
 
+// until drops the changes after `to`, the changes are ordered by time of change
+func until(changes []*ApptChange, to time.Time) []*ApptChange {
+	for i, change := range changes {
+		if change.Time.After(to) {
+			return changes[:i]
+		}
+	}
+	return changes
+}
+
+// quarantined separates the changes which could not be interpreted
+func quarantined(changes []*ApptChange) (valid, unparsed []*ApptChange) {
+	for _, change := range changes {
+		if change.Error != "" {
+			unparsed = append(unparsed, change)
+			continue
+		}
+		valid = append(valid, change)
+	}
+	return valid, unparsed
+}
+
+// enrich attaches the master data of the patients to the changes and upcoming appointments
+// the patients of a run are loaded at once, a failed lookup only costs the master data
+func (job *changedApptsJob) enrich(changes []*ApptChange, upcoming []*UpcomingDay) {
+	logger := job.logger()
+
+	var ids []int
+	for _, change := range changes {
+		ids = append(ids, change.PatientID)
+	}
+	for _, day := range upcoming {
+		for _, appt := range day.Appts {
+			ids = append(ids, appt.PatientID)
+		}
+	}
+	if len(ids) == 0 {
 		return
 	}
 
-	if err := job.mailer.SendMessage("text/html", buf.String()); err != nil {
-		log.Error().
+	patients, err := job.cfg.Directory.LookupPatients(ids)
+	if err != nil {
+		logger.Error().
 			Err(err).
-			Msg("could not send message")
-
+			Msg("could not load patient master data, sending the changes without")
 		return
 	}
 
-	// set lastRun time
-	job.lastRun = run
+	missing := 0
+	for _, change := range changes {
+		if change.Patient = patients[change.PatientID]; change.Patient == nil {
+			missing++
+		}
+	}
+	for _, day := range upcoming {
+		for _, appt := range day.Appts {
+			if appt.Patient = patients[appt.PatientID]; appt.Patient == nil {
+				missing++
+			}
+		}
+	}
+	if missing > 0 {
+		logger.Warn().
+			Int("missing", missing).
+			Msg("patients not found in the patient table")
+	}
+}
+
+// GroupUpcoming groups the appointments of `days` days starting at the day of `now`
+// like the upcoming appointments of TypeChanges
+func GroupUpcoming(now time.Time, days int, appts []*UpcomingAppt) []*UpcomingDay {
+	return groupByDay(truncateDay(now), days, appts)
+}
+
+// groupByDay groups the appointments by day, starting at `today`
+// every day gets an entry, also without appointments
+func groupByDay(today time.Time, days int, appts []*UpcomingAppt) []*UpcomingDay {
+	grouped := make([]*UpcomingDay, days)
+	for i := range grouped {
+		grouped[i] = &UpcomingDay{
+			Day:    today.AddDate(0, 0, i),
+			Offset: i,
+		}
+	}
+
+	for _, appt := range appts {
+		day := truncateDay(appt.Appointment.In(today.Location()))
+		for _, g := range grouped {
+			if g.Day.Equal(day) {
+				g.Appts = append(g.Appts, appt)
+				break
+			}
+		}
+	}
+
+	return grouped
 }
@@ -1,9 +1,12 @@
 package job
 
 import (
+	"errors"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/emed-appts/emed-mailer/internal/collector/tzinfo"
 	"github.com/emed-appts/emed-mailer/test"
 
 	"github.com/stretchr/testify/assert"
@@ -38,18 +41,497 @@ func TestChangedApptsJob_Run(t *testing.T) {
 		}, nil).
 		Once()
 
-	m := &MockMailer{}
+	m := &MockNotifier{}
 	m.
-		On("SendMessage", "text/html", mock.AnythingOfType("string")).
+		On("Notify", mock.MatchedBy(func(msg *Message) bool { return msg.ContentType == "text/html" })).
 		Return(nil).
 		Once()
 
-	job := &changedApptsJob{c, m, lastRun}
+	w := &MockWatermark{}
+	w.
+		On("Save", mock.AnythingOfType("time.Time")).
+		Return(nil).
+		Once()
+
+	job := &changedApptsJob{
+		base: base{
+			lastRun: lastRun,
+			cfg:     Config{Watermark: w},
+		},
+		collector: c,
+		notifier:  m,
+	}
 	job.Run()
 
 	// test that lastRun has been updated
 	assert.True(t, job.lastRun.After(lastRun))
+	assert.Equal(t, ResultSuccess, job.Status().Result)
+	assert.Equal(t, 2, job.Status().Count)
 
 	c.AssertExpectations(t)
 	m.AssertExpectations(t)
+	w.AssertExpectations(t)
+}
+
+func TestChangedApptsJob_RunMinGap(t *testing.T) {
+	lastRun := time.Now().Add(time.Minute * -5)
+
+	c := &MockCollector{}
+	m := &MockNotifier{}
+
+	job := &changedApptsJob{
+		base: base{
+			lastRun: lastRun,
+			cfg:     Config{MinGap: time.Minute * 15},
+		},
+		collector: c,
+		notifier:  m,
+	}
+	job.Run()
+
+	// test that the run has been skipped
+	assert.Equal(t, lastRun, job.lastRun)
+
+	c.AssertNotCalled(t, "CollectChangedAppts", mock.Anything)
+	m.AssertNotCalled(t, "Notify", mock.Anything)
+}
+
+func TestChangedApptsJob_RunSkipEmpty(t *testing.T) {
+	lastRun := time.Now().Add(time.Hour * -24)
+
+	c := &MockCollector{}
+	c.
+		On("CollectChangedAppts", lastRun).
+		Return([]*ApptChange{
+			{
+				Time:        time.Now(),
+				Appointment: time.Now(),
+				PatientID:   2,
+				PatientName: "Firstname Lastname",
+				IsBooking:   false,
+			},
+		}, nil).
+		Once()
+
+	m := &MockNotifier{}
+
+	w := &MockWatermark{}
+	w.
+		On("Save", mock.AnythingOfType("time.Time")).
+		Return(nil).
+		Once()
+
+	job := &changedApptsJob{
+		base: base{
+			lastRun: lastRun,
+			cfg: Config{
+				Watermark: w,
+				Filter:    FilterBookings,
+				SendEmpty: false,
+			},
+		},
+		collector: c,
+		notifier:  m,
+	}
+	job.Run()
+
+	// test that lastRun has been updated without sending a message
+	assert.True(t, job.lastRun.After(lastRun))
+
+	c.AssertExpectations(t)
+	m.AssertNotCalled(t, "Notify", mock.Anything)
+	w.AssertExpectations(t)
+}
+
+func TestChangedApptsJob_RunReport(t *testing.T) {
+	lastRun := time.Now().Add(time.Hour * 24 * -7)
+
+	c := &MockCollector{}
+	c.
+		On("CollectChangedAppts", lastRun).
+		Return([]*ApptChange{
+			{
+				Time:        time.Now().Add(time.Hour * -30),
+				Appointment: time.Now().Add(time.Hour * 24),
+				PatientID:   1,
+				PatientName: "Firstname Lastname",
+				IsBooking:   true,
+			},
+		}, nil).
+		Once()
+
+	m := &MockNotifier{}
+	m.
+		On("Notify", mock.MatchedBy(func(msg *Message) bool {
+			return strings.Contains(msg.Body, "<svg")
+		})).
+		Return(nil).
+		Once()
+
+	job := &changedApptsJob{
+		base: base{
+			lastRun: lastRun,
+			cfg:     Config{Type: TypeReport},
+		},
+		collector: c,
+		notifier:  m,
+	}
+	job.Run()
+
+	assert.True(t, job.lastRun.After(lastRun))
+
+	c.AssertExpectations(t)
+	m.AssertExpectations(t)
+}
+
+func TestChangedApptsJob_RunUpcoming(t *testing.T) {
+	lastRun := time.Now().Add(time.Hour * -24)
+	tomorrow := truncateDay(time.Now()).AddDate(0, 0, 1).Add(time.Hour * 9)
+
+	c := &MockCollector{}
+	c.
+		On("CollectChangedAppts", lastRun).
+		Return([]*ApptChange{}, nil).
+		Once()
+	c.
+		On("CollectUpcomingAppts", mock.AnythingOfType("time.Time"), truncateDay(time.Now()).AddDate(0, 0, 2)).
+		Return([]*UpcomingAppt{
+			{
+				Appointment: tomorrow,
+				BookedAt:    time.Now().Add(time.Hour * -2),
+				PatientID:   3,
+				PatientName: "Upcoming Patient",
+			},
+		}, nil).
+		Once()
+
+	m := &MockNotifier{}
+	m.
+		On("Notify", mock.MatchedBy(func(msg *Message) bool {
+			return strings.Contains(msg.Body, "Heute") &&
+				strings.Contains(msg.Body, "Morgen") &&
+				strings.Contains(msg.Body, "Upcoming Patient")
+		})).
+		Return(nil).
+		Once()
+
+	job := &changedApptsJob{
+		base: base{
+			lastRun: lastRun,
+			cfg: Config{
+				SendEmpty:    true,
+				UpcomingDays: 2,
+			},
+		},
+		collector: c,
+		notifier:  m,
+	}
+	job.Run()
+
+	c.AssertExpectations(t)
+	m.AssertExpectations(t)
+}
+
+func TestChangedApptsJob_RunAlert(t *testing.T) {
+	lastRun := time.Now().Add(time.Hour * -24)
+
+	c := &MockCollector{}
+	c.
+		On("CollectChangedAppts", lastRun).
+		Return(nil, errors.New("connection refused")).
+		Times(3)
+	c.
+		On("CollectChangedAppts", lastRun).
+		Return([]*ApptChange{}, nil).
+		Once()
+
+	a := &MockAlerter{}
+	a.
+		On("Failed", mock.MatchedBy(func(alert *Alert) bool {
+			return alert.Failures == 2 &&
+				alert.From.Equal(lastRun) &&
+				strings.Contains(alert.Error, "connection refused")
+		})).
+		Return(nil).
+		Once()
+	a.
+		On("Recovered", mock.MatchedBy(func(alert *Alert) bool {
+			return alert.Failures == 3 && alert.From.Equal(lastRun)
+		})).
+		Return(nil).
+		Once()
+
+	job := &changedApptsJob{
+		base: base{
+			lastRun: lastRun,
+			cfg: Config{
+				Alerter:    a,
+				AlertAfter: 2,
+			},
+		},
+		collector: c,
+		notifier:  &MockNotifier{},
+	}
+	for i := 0; i < 4; i++ {
+		job.Run()
+	}
+
+	assert.Equal(t, ResultSuccess, job.Status().Result)
+	assert.Zero(t, job.Status().Failures)
+
+	c.AssertExpectations(t)
+	a.AssertExpectations(t)
+}
+
+func TestChangedApptsJob_RunAlertRestored(t *testing.T) {
+	lastRun := time.Now().Add(time.Hour * -24)
+
+	c := &MockCollector{}
+	c.
+		On("CollectChangedAppts", lastRun).
+		Return(nil, errors.New("connection refused")).
+		Twice()
+
+	a := &MockAlerter{}
+	a.
+		On("Failed", mock.MatchedBy(func(alert *Alert) bool {
+			return alert.Failures == 2
+		})).
+		Return(nil).
+		Once()
+
+	newJob := func() *changedApptsJob {
+		return &changedApptsJob{
+			base: base{
+				lastRun: lastRun,
+				cfg: Config{
+					Alerter:    a,
+					AlertAfter: 2,
+				},
+			},
+			collector: c,
+			notifier:  &MockNotifier{},
+		}
+	}
+
+	// a reload replaces the job after the first failure
+	job := newJob()
+	job.Run()
+	replaced := newJob()
+	replaced.Restore(job.Status())
+	replaced.Run()
+
+	assert.Equal(t, 2, replaced.Status().Failures)
+
+	c.AssertExpectations(t)
+	a.AssertExpectations(t)
+}
+
+func TestChangedApptsJob_RunWindow(t *testing.T) {
+	lastRun := time.Now().Add(time.Hour * -1)
+	from := time.Date(2026, 10, 1, 6, 0, 0, 0, time.Local)
+	to := from.AddDate(0, 0, 1)
+
+	c := &MockCollector{}
+	c.
+		On("CollectChangedAppts", from).
+		Return([]*ApptChange{
+			{
+				Time:        from.Add(time.Hour),
+				Appointment: to,
+				PatientID:   1,
+				PatientName: "Within Window",
+				IsBooking:   true,
+			},
+			{
+				// changes after the window are reported by the next window
+				Time:        to.Add(time.Hour),
+				Appointment: to,
+				PatientID:   2,
+				PatientName: "After Window",
+				IsBooking:   true,
+			},
+		}, nil).
+		Once()
+
+	m := &MockNotifier{}
+	m.
+		On("Notify", mock.MatchedBy(func(msg *Message) bool {
+			return strings.Contains(msg.Body, "Within Window") &&
+				!strings.Contains(msg.Body, "After Window")
+		})).
+		Return(nil).
+		Once()
+
+	job := &changedApptsJob{
+		base: base{
+			lastRun: lastRun,
+		},
+		collector: c,
+		notifier:  m,
+	}
+	count, err := job.RunWindow(from, to)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+	// test that lastRun has been kept
+	assert.Equal(t, lastRun, job.lastRun)
+
+	c.AssertExpectations(t)
+	m.AssertExpectations(t)
+}
+
+func TestChangedApptsJob_RunUnparsed(t *testing.T) {
+	lastRun := time.Now().Add(time.Hour * -24)
+
+	c := &MockCollector{}
+	c.
+		On("CollectChangedAppts", lastRun).
+		Return([]*ApptChange{
+			{
+				Time:        time.Now().Add(time.Hour * -1),
+				PatientID:   4,
+				PatientName: "Unparsed Patient",
+				IsBooking:   true,
+				Error:       `zeit "9:5x" matches none of the formats 15:4`,
+			},
+		}, nil).
+		Once()
+
+	// entries which could not be interpreted are sent without SendEmpty
+	m := &MockNotifier{}
+	m.
+		On("Notify", mock.MatchedBy(func(msg *Message) bool {
+			return strings.Contains(msg.Body, "Unparsed Patient") &&
+				strings.Contains(msg.Body, "matches none of the formats") &&
+				strings.Contains(msg.Text, "Nicht interpretierbar: 1")
+		})).
+		Return(nil).
+		Once()
+
+	job := &changedApptsJob{
+		base: base{
+			lastRun: lastRun,
+		},
+		collector: c,
+		notifier:  m,
+	}
+	job.Run()
+
+	assert.Equal(t, ResultSuccess, job.Status().Result)
+	assert.Equal(t, 0, job.Status().Count)
+
+	c.AssertExpectations(t)
+	m.AssertExpectations(t)
+}
+
+func TestChangedApptsJob_RunEnriched(t *testing.T) {
+	lastRun := time.Now().Add(time.Hour * -24)
+
+	c := &MockCollector{}
+	c.
+		On("CollectChangedAppts", lastRun).
+		Return([]*ApptChange{
+			{
+				Time:        time.Now().Add(time.Hour * -2),
+				Appointment: time.Now().Add(time.Hour * 24),
+				PatientID:   1,
+				PatientName: "Known Patient",
+				IsBooking:   true,
+			},
+			{
+				Time:        time.Now().Add(time.Hour * -1),
+				Appointment: time.Now().Add(time.Hour * 48),
+				PatientID:   2,
+				PatientName: "Missing Patient",
+				IsBooking:   true,
+			},
+		}, nil).
+		Once()
+
+	// the patients of a run are looked up at once, missing ones are left out
+	d := &MockPatientDirectory{}
+	d.
+		On("LookupPatients", []int{1, 2}).
+		Return(map[int]*Patient{
+			1: {ID: 1, Phone: "0664 1234567", Insurance: "ÖGK", NewPatient: true},
+		}, nil).
+		Once()
+
+	m := &MockNotifier{}
+	m.
+		On("Notify", mock.MatchedBy(func(msg *Message) bool {
+			return strings.Contains(msg.Body, "Tel. 0664 1234567") &&
+				strings.Contains(msg.Body, "ÖGK") &&
+				strings.Count(msg.Body, ">NEU<") == 1 &&
+				strings.Contains(msg.Body, "Missing Patient")
+		})).
+		Return(nil).
+		Once()
+
+	job := &changedApptsJob{
+		base: base{
+			lastRun: lastRun,
+			cfg:     Config{Directory: d},
+		},
+		collector: c,
+		notifier:  m,
+	}
+	job.Run()
+
+	assert.Equal(t, ResultSuccess, job.Status().Result)
+	assert.Equal(t, 2, job.Status().Count)
+
+	// a failed lookup only costs the master data
+	c.
+		On("CollectChangedAppts", mock.AnythingOfType("time.Time")).
+		Return([]*ApptChange{
+			{
+				Time:        time.Now(),
+				Appointment: time.Now().Add(time.Hour * 24),
+				PatientID:   1,
+				PatientName: "Known Patient",
+				IsBooking:   true,
+			},
+		}, nil).
+		Once()
+	d.
+		On("LookupPatients", []int{1}).
+		Return(nil, errors.New("patient table not found")).
+		Once()
+	m.
+		On("Notify", mock.MatchedBy(func(msg *Message) bool {
+			return strings.Contains(msg.Body, "Known Patient") && !strings.Contains(msg.Body, ">NEU<")
+		})).
+		Return(nil).
+		Once()
+
+	job.lastRun = lastRun
+	job.Run()
+
+	assert.Equal(t, ResultSuccess, job.Status().Result)
+
+	c.AssertExpectations(t)
+	d.AssertExpectations(t)
+	m.AssertExpectations(t)
+}
+
+func TestGroupUpcoming_DST(t *testing.T) {
+	vienna, err := tzinfo.LoadLocation("Europe/Vienna")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the appointments are given in UTC, the days are the ones of Vienna across the end of DST
+	late := &UpcomingAppt{Appointment: time.Date(2026, 10, 25, 22, 30, 0, 0, time.UTC), PatientID: 1}
+	early := &UpcomingAppt{Appointment: time.Date(2026, 10, 25, 23, 30, 0, 0, time.UTC), PatientID: 2}
+
+	days := GroupUpcoming(time.Date(2026, 10, 24, 12, 0, 0, 0, vienna), 3, []*UpcomingAppt{late, early})
+
+	if assert.Len(t, days, 3) {
+		assert.Equal(t, time.Date(2026, 10, 25, 0, 0, 0, 0, vienna), days[1].Day)
+		assert.Equal(t, []*UpcomingAppt{late}, days[1].Appts)
+		assert.Equal(t, time.Date(2026, 10, 26, 0, 0, 0, 0, vienna), days[2].Day)
+		assert.Equal(t, []*UpcomingAppt{early}, days[2].Appts)
+	}
 }
@@ -32,3 +32,26 @@ func (_m *MockCollector) CollectChangedAppts(_a0 time.Time) ([]*ApptChange, erro
 
 	return r0, r1
 }
+
+// CollectUpcomingAppts provides a mock function with given fields: _a0, _a1
+func (_m *MockCollector) CollectUpcomingAppts(_a0 time.Time, _a1 time.Time) ([]*UpcomingAppt, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 []*UpcomingAppt
+	if rf, ok := ret.Get(0).(func(time.Time, time.Time) []*UpcomingAppt); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*UpcomingAppt)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(time.Time, time.Time) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
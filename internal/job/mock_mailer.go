@@ -1,38 +0,0 @@
-// Code generated by mockery v1.0.0. DO NOT EDIT.
-
-package job
-
-import mock "github.com/stretchr/testify/mock"
-
-// MockMailer is an autogenerated mock type for the Mailer type
-type MockMailer struct {
-	mock.Mock
-}
-
-// Run provides a mock function with given fields: _a0
-func (_m *MockMailer) Run(_a0 <-chan struct{}) error {
-	ret := _m.Called(_a0)
-
-	var r0 error
-	if rf, ok := ret.Get(0).(func(<-chan struct{}) error); ok {
-		r0 = rf(_a0)
-	} else {
-		r0 = ret.Error(0)
-	}
-
-	return r0
-}
-
-// SendMessage provides a mock function with given fields: _a0, _a1
-func (_m *MockMailer) SendMessage(_a0 string, _a1 string) error {
-	ret := _m.Called(_a0, _a1)
-
-	var r0 error
-	if rf, ok := ret.Get(0).(func(string, string) error); ok {
-		r0 = rf(_a0, _a1)
-	} else {
-		r0 = ret.Error(0)
-	}
-
-	return r0
-}
@@ -0,0 +1,257 @@
+package job
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/emed-appts/emed-mailer/internal/metrics"
+	"github.com/emed-appts/emed-mailer/internal/template"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// templates of the patient notifications
+const (
+	ConfirmationTemplate = "patient_confirmation.tmpl"
+	CancellationTemplate = "patient_cancellation.tmpl"
+	ReminderTemplate     = "patient_reminder.tmpl"
+)
+
+// Patient struct
+type Patient struct {
+	ID     int
+	Email  string
+	OptOut bool
+
+	// master data shown next to the changes, empty if the column is not configured
+	Phone      string
+	Insurance  string
+	NewPatient bool
+}
+
+// PatientDirectory interface
+type PatientDirectory interface {
+	// loads patients by id, unknown patients are missing in the result
+	LookupPatients([]int) (map[int]*Patient, error)
+}
+
+// Sender interface
+type Sender interface {
+	// sends a message to recipients with subject, content type and text
+	// it returns once the message is delivered, the notification is recorded as sent then
+	SendMessageTo(string, string, string, string) error
+}
+
+// Ledger interface
+type Ledger interface {
+	// reports if a notification has been sent
+	Sent(string) (bool, error)
+	// records a sent notification
+	Record(string, time.Time) error
+}
+
+// notification sent to a patient
+type notification struct {
+	key         string
+	template    string
+	patientID   int
+	patientName string
+	appointment time.Time
+}
+
+type patientJob struct {
+	base
+	collector Collector
+	directory PatientDirectory
+	sender    Sender
+	ledger    Ledger
+}
+
+// NewPatientJob creates a Job notifying patients about their appointments
+// it confirms bookings and cancellations and reminds `cfg.ReminderBefore` the appointment
+func NewPatientJob(collector Collector, directory PatientDirectory, sender Sender, ledger Ledger, lastRun time.Time, cfg Config) Job {
+	return &patientJob{
+		base: base{
+			lastRun: lastRun,
+			cfg:     cfg,
+			status:  Status{LastRun: lastRun},
+		},
+		collector: collector,
+		directory: directory,
+		sender:    sender,
+		ledger:    ledger,
+	}
+}
+
+// Run executes the job once
+// the job only advances if all notifications have been sent, the ledger prevents duplicates on retry
+func (job *patientJob) Run() {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	// store execution time
+	run := time.Now()
+
+	if job.tooEarly(run) {
+		return
+	}
+
+	count, err := job.process(job.lastRun, run)
+	if err != nil {
+		job.fail(run, err)
+		return
+	}
+
+	job.advance(run, count)
+}
+
+// RunWindow executes the job once for the changes from `from` until `to`
+// appointments before `to` are not notified, the last run is not changed
+func (job *patientJob) RunWindow(from, to time.Time) (int, error) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	return job.process(from, to)
+}
+
+// process notifies the patients about the changes from `from` until `to`
+// and reminds them of their appointments within the reminder period after `to`
+func (job *patientJob) process(from, to time.Time) (int, error) {
+	logger := job.logger()
+
+	changedAppts, err := job.collector.CollectChangedAppts(from)
+	if err != nil {
+		return 0, errors.Wrap(err, "collect updated appointments failed")
+	}
+	changedAppts = until(changedAppts, to)
+
+	var notifications []*notification
+	for _, change := range changedAppts {
+		// the digest shows entries which could not be interpreted
+		if change.Error != "" {
+			continue
+		}
+		// past appointments are not worth a notification
+		if change.Appointment.Before(to) {
+			continue
+		}
+		tmpl := CancellationTemplate
+		if change.IsBooking {
+			tmpl = ConfirmationTemplate
+		}
+
+		notifications = append(notifications, &notification{
+			key:         fmt.Sprintf("%s/%d/%s/%s", tmpl, change.PatientID, change.Appointment.UTC().Format(time.RFC3339), change.Time.UTC().Format(time.RFC3339)),
+			template:    tmpl,
+			patientID:   change.PatientID,
+			patientName: change.PatientName,
+			appointment: change.Appointment,
+		})
+	}
+
+	if job.cfg.ReminderBefore > 0 {
+		upcomingAppts, err := job.collector.CollectUpcomingAppts(to, to.Add(job.cfg.ReminderBefore))
+		if err != nil {
+			return 0, errors.Wrap(err, "collect upcoming appointments failed")
+		}
+
+		for _, appt := range upcomingAppts {
+			// bookings within the reminder period already got a confirmation
+			if appt.BookedAt.After(appt.Appointment.Add(-job.cfg.ReminderBefore)) {
+				continue
+			}
+
+			notifications = append(notifications, &notification{
+				key:         fmt.Sprintf("%s/%d/%s", ReminderTemplate, appt.PatientID, appt.Appointment.UTC().Format(time.RFC3339)),
+				template:    ReminderTemplate,
+				patientID:   appt.PatientID,
+				patientName: appt.PatientName,
+				appointment: appt.Appointment,
+			})
+		}
+	}
+
+	if len(notifications) == 0 {
+		return 0, nil
+	}
+
+	ids := make([]int, len(notifications))
+	for i, n := range notifications {
+		ids[i] = n.patientID
+	}
+	patients, err := job.directory.LookupPatients(ids)
+	if err != nil {
+		return 0, errors.Wrap(err, "lookup of patients failed")
+	}
+
+	failed := 0
+	for _, n := range notifications {
+		if err := job.notify(n, patients[n.patientID]); err != nil {
+			failed++
+			logger.Error().
+				Err(err).
+				Int("patientID", n.patientID).
+				Str("template", n.template).
+				Msg("could not notify patient")
+		}
+	}
+
+	if failed > 0 {
+		return 0, errors.Errorf("%d of %d notifications failed", failed, len(notifications))
+	}
+
+	return len(notifications), nil
+}
+
+// notify sends a notification unless it has been sent already or the patient can not be notified
+func (job *patientJob) notify(n *notification, patient *Patient) error {
+	logger := job.logger()
+
+	sent, err := job.ledger.Sent(n.key)
+	if err != nil {
+		return err
+	}
+	if sent {
+		return nil
+	}
+
+	switch {
+	case patient == nil:
+		logger.Debug().
+			Int("patientID", n.patientID).
+			Msg("skip notification, patient not found")
+		return nil
+	case patient.OptOut:
+		logger.Debug().
+			Int("patientID", n.patientID).
+			Msg("skip notification, patient opted out")
+		return nil
+	case patient.Email == "":
+		logger.Debug().
+			Int("patientID", n.patientID).
+			Msg("skip notification, patient has no email address")
+		return nil
+	}
+
+	timer := prometheus.NewTimer(metrics.RenderDuration.WithLabelValues(n.template))
+	rendered, err := template.Render(n.template, struct {
+		PatientID   int
+		PatientName string
+		Appointment time.Time
+	}{
+		PatientID:   n.patientID,
+		PatientName: n.patientName,
+		Appointment: n.appointment,
+	})
+	timer.ObserveDuration()
+	if err != nil {
+		return err
+	}
+
+	if err := job.sender.SendMessageTo(patient.Email, rendered.Subject, "text/html", rendered.Body); err != nil {
+		return err
+	}
+
+	return job.ledger.Record(n.key, time.Now())
+}
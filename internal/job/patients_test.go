@@ -0,0 +1,156 @@
+package job
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestPatientJob_Run(t *testing.T) {
+	lastRun := time.Now().Add(time.Hour * -1)
+	tomorrow := time.Now().Add(time.Hour * 20)
+
+	c := &MockCollector{}
+	c.
+		On("CollectChangedAppts", lastRun).
+		Return([]*ApptChange{
+			{
+				Time:        time.Now(),
+				Appointment: tomorrow,
+				PatientID:   1,
+				PatientName: "Firstname Lastname",
+				IsBooking:   true,
+			},
+			{
+				// past appointments are not notified
+				Time:        time.Now(),
+				Appointment: time.Now().Add(time.Hour * -2),
+				PatientID:   1,
+				PatientName: "Firstname Lastname",
+				IsBooking:   false,
+			},
+			{
+				Time:        time.Now(),
+				Appointment: tomorrow,
+				PatientID:   2,
+				PatientName: "Opted Out",
+				IsBooking:   false,
+			},
+		}, nil).
+		Once()
+	c.
+		On("CollectUpcomingAppts", mock.AnythingOfType("time.Time"), mock.AnythingOfType("time.Time")).
+		Return([]*UpcomingAppt{
+			{
+				Appointment: tomorrow,
+				BookedAt:    time.Now().Add(time.Hour * -72),
+				PatientID:   3,
+				PatientName: "Already Reminded",
+			},
+			{
+				// booked within the reminder period, confirmed instead
+				Appointment: tomorrow,
+				BookedAt:    time.Now(),
+				PatientID:   1,
+				PatientName: "Firstname Lastname",
+			},
+		}, nil).
+		Once()
+
+	d := &MockPatientDirectory{}
+	d.
+		On("LookupPatients", []int{1, 2, 3}).
+		Return(map[int]*Patient{
+			1: {ID: 1, Email: "patient@example.com"},
+			2: {ID: 2, Email: "optedout@example.com", OptOut: true},
+			3: {ID: 3, Email: "reminded@example.com"},
+		}, nil).
+		Once()
+
+	l := &MockLedger{}
+	l.
+		On("Sent", mock.MatchedBy(func(key string) bool { return key != "" })).
+		Return(func(key string) bool { return key[:len(ReminderTemplate)] == ReminderTemplate }, nil)
+	l.
+		On("Record", mock.AnythingOfType("string"), mock.AnythingOfType("time.Time")).
+		Return(nil).
+		Once()
+
+	s := &MockSender{}
+	s.
+		On("SendMessageTo", "patient@example.com", mock.MatchedBy(func(subject string) bool { return subject != "" }), "text/html", mock.AnythingOfType("string")).
+		Return(nil).
+		Once()
+
+	w := &MockWatermark{}
+	w.
+		On("Save", mock.AnythingOfType("time.Time")).
+		Return(nil).
+		Once()
+
+	job := NewPatientJob(c, d, s, l, lastRun, Config{Watermark: w, ReminderBefore: time.Hour * 24})
+	job.Run()
+
+	// test that lastRun has been updated
+	assert.True(t, job.LastRun().After(lastRun))
+
+	c.AssertExpectations(t)
+	d.AssertExpectations(t)
+	l.AssertExpectations(t)
+	s.AssertExpectations(t)
+	w.AssertExpectations(t)
+}
+
+func TestPatientJob_RunFailed(t *testing.T) {
+	lastRun := time.Now().Add(time.Hour * -1)
+
+	c := &MockCollector{}
+	c.
+		On("CollectChangedAppts", lastRun).
+		Return([]*ApptChange{
+			{
+				Time:        time.Now(),
+				Appointment: time.Now().Add(time.Hour * 48),
+				PatientID:   1,
+				PatientName: "Firstname Lastname",
+				IsBooking:   true,
+			},
+		}, nil).
+		Once()
+
+	d := &MockPatientDirectory{}
+	d.
+		On("LookupPatients", []int{1}).
+		Return(map[int]*Patient{
+			1: {ID: 1, Email: "patient@example.com"},
+		}, nil).
+		Once()
+
+	l := &MockLedger{}
+	l.
+		On("Sent", mock.AnythingOfType("string")).
+		Return(false, nil).
+		Once()
+
+	s := &MockSender{}
+	s.
+		On("SendMessageTo", "patient@example.com", mock.AnythingOfType("string"), "text/html", mock.AnythingOfType("string")).
+		Return(errors.New("smtp unavailable")).
+		Once()
+
+	job := NewPatientJob(c, d, s, l, lastRun, Config{})
+	job.Run()
+
+	// test that lastRun has not been updated, so the notification is retried
+	assert.Equal(t, lastRun, job.LastRun())
+	assert.Equal(t, ResultFailed, job.Status().Result)
+	assert.Equal(t, lastRun, job.Status().LastRun)
+
+	c.AssertExpectations(t)
+	d.AssertExpectations(t)
+	l.AssertExpectations(t)
+	s.AssertExpectations(t)
+}
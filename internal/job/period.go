@@ -0,0 +1,51 @@
+package job
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// periodCollector reads the changes of a period once and serves the runs of its windows from them
+type periodCollector struct {
+	Collector
+	from time.Time
+
+	mu        sync.Mutex
+	changes   []*ApptChange
+	collected bool
+}
+
+// NewPeriodCollector returns a Collector reading the changes since `from` once from `collector`,
+// later calls get the changes after their time from the result, e.g. the windows of a backfill
+// calls before `from` and upcoming appointments are passed to `collector`
+func NewPeriodCollector(collector Collector, from time.Time) Collector {
+	return &periodCollector{
+		Collector: collector,
+		from:      from,
+	}
+}
+
+// CollectChangedAppts returns the changes after `since`
+func (c *periodCollector) CollectChangedAppts(since time.Time) ([]*ApptChange, error) {
+	if since.Before(c.from) {
+		return c.Collector.CollectChangedAppts(since)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.collected {
+		changes, err := c.Collector.CollectChangedAppts(c.from)
+		if err != nil {
+			return nil, err
+		}
+		c.changes, c.collected = changes, true
+	}
+
+	// the changes are ordered by time of change
+	i := sort.Search(len(c.changes), func(i int) bool {
+		return c.changes[i].Time.After(since)
+	})
+	return c.changes[i:len(c.changes):len(c.changes)], nil
+}
@@ -0,0 +1,36 @@
+package job
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeriodCollector(t *testing.T) {
+	from := time.Date(2026, 10, 19, 6, 0, 0, 0, time.UTC)
+	changes := []*ApptChange{
+		{PatientID: 1, Time: from.Add(time.Hour)},
+		{PatientID: 2, Time: from.Add(2 * time.Hour)},
+		{PatientID: 3, Time: from.Add(3 * time.Hour)},
+	}
+
+	c := &MockCollector{}
+	c.
+		On("CollectChangedAppts", from).
+		Return(changes, nil).
+		Once()
+
+	period := NewPeriodCollector(c, from)
+
+	collected, err := period.CollectChangedAppts(from)
+	if assert.NoError(t, err) {
+		assert.Len(t, collected, 3)
+	}
+	collected, err = period.CollectChangedAppts(from.Add(2 * time.Hour))
+	if assert.NoError(t, err) && assert.Len(t, collected, 1) {
+		assert.Equal(t, 3, collected[0].PatientID)
+	}
+
+	c.AssertExpectations(t)
+}
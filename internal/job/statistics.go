@@ -0,0 +1,216 @@
+package job
+
+import (
+	"fmt"
+	"html/template"
+	"sort"
+	"time"
+
+	"github.com/emed-appts/emed-mailer/internal/chart"
+)
+
+// number of entries of ranked statistics
+const (
+	topSlots      = 5
+	topCancellers = 10
+)
+
+// Statistics aggregates the appointment changes of a period
+type Statistics struct {
+	From time.Time
+	To   time.Time
+
+	Bookings         int
+	Cancellations    int
+	CancellationRate float64
+
+	PerDay []DayCount
+	// PerHour counts the booked appointments per hour of the day
+	PerHour  [24]int
+	LeadTime LeadTime
+
+	BusiestSlots     []SlotCount
+	RepeatCancellers []PatientCount
+}
+
+// DayCount counts the changes of a single day
+type DayCount struct {
+	Day           time.Time
+	Bookings      int
+	Cancellations int
+}
+
+// LeadTime describes the time between booking and appointment
+type LeadTime struct {
+	Average time.Duration
+	Median  time.Duration
+	Min     time.Duration
+	Max     time.Duration
+}
+
+// SlotCount counts the bookings of an hour of a weekday
+type SlotCount struct {
+	Weekday time.Weekday
+	Hour    int
+	Count   int
+}
+
+// PatientCount counts the cancellations of a patient
+type PatientCount struct {
+	PatientID   int
+	PatientName string
+	Count       int
+}
+
+// NewStatistics aggregates `changes` of the period from `from` to `to`
+func NewStatistics(from, to time.Time, changes []*ApptChange) *Statistics {
+	stats := &Statistics{
+		From: from,
+		To:   to,
+	}
+
+	// one entry per day, also for days without changes
+	days := map[time.Time]*DayCount{}
+	for day := truncateDay(from); !day.After(to); day = day.AddDate(0, 0, 1) {
+		stats.PerDay = append(stats.PerDay, DayCount{Day: day})
+	}
+	for i := range stats.PerDay {
+		days[stats.PerDay[i].Day] = &stats.PerDay[i]
+	}
+
+	var leadTimes []time.Duration
+	slots := map[SlotCount]int{}
+	cancellers := map[int]*PatientCount{}
+
+	for _, change := range changes {
+		day := days[truncateDay(change.Time.In(from.Location()))]
+
+		if change.IsBooking {
+			stats.Bookings++
+			if day != nil {
+				day.Bookings++
+			}
+
+			leadTimes = append(leadTimes, change.Appointment.Sub(change.Time))
+			slots[SlotCount{Weekday: change.Appointment.Weekday(), Hour: change.Appointment.Hour()}]++
+			stats.PerHour[change.Appointment.Hour()]++
+			continue
+		}
+
+		stats.Cancellations++
+		if day != nil {
+			day.Cancellations++
+		}
+
+		canceller, ok := cancellers[change.PatientID]
+		if !ok {
+			canceller = &PatientCount{PatientID: change.PatientID, PatientName: change.PatientName}
+			cancellers[change.PatientID] = canceller
+		}
+		canceller.Count++
+	}
+
+	if total := stats.Bookings + stats.Cancellations; total > 0 {
+		stats.CancellationRate = float64(stats.Cancellations) / float64(total)
+	}
+
+	stats.LeadTime = newLeadTime(leadTimes)
+
+	for slot, count := range slots {
+		slot.Count = count
+		stats.BusiestSlots = append(stats.BusiestSlots, slot)
+	}
+	sort.Slice(stats.BusiestSlots, func(i, j int) bool {
+		a, b := stats.BusiestSlots[i], stats.BusiestSlots[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		if a.Weekday != b.Weekday {
+			return a.Weekday < b.Weekday
+		}
+		return a.Hour < b.Hour
+	})
+	if len(stats.BusiestSlots) > topSlots {
+		stats.BusiestSlots = stats.BusiestSlots[:topSlots]
+	}
+
+	for _, canceller := range cancellers {
+		if canceller.Count > 1 {
+			stats.RepeatCancellers = append(stats.RepeatCancellers, *canceller)
+		}
+	}
+	sort.Slice(stats.RepeatCancellers, func(i, j int) bool {
+		a, b := stats.RepeatCancellers[i], stats.RepeatCancellers[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.PatientID < b.PatientID
+	})
+	if len(stats.RepeatCancellers) > topCancellers {
+		stats.RepeatCancellers = stats.RepeatCancellers[:topCancellers]
+	}
+
+	return stats
+}
+
+// DayChart renders bookings and cancellations per day
+func (stats *Statistics) DayChart() template.HTML {
+	labels := make([]string, len(stats.PerDay))
+	bookings := make([]float64, len(stats.PerDay))
+	cancellations := make([]float64, len(stats.PerDay))
+	for i, day := range stats.PerDay {
+		labels[i] = day.Day.Format("02.01.")
+		bookings[i] = float64(day.Bookings)
+		cancellations[i] = float64(day.Cancellations)
+	}
+
+	return chart.Bars(labels,
+		chart.Series{Name: "Reservierungen", Color: "#5cb85c", Values: bookings},
+		chart.Series{Name: "Stornos", Color: "#f25454", Values: cancellations},
+	)
+}
+
+// HourChart renders the booked appointments per hour of the day
+func (stats *Statistics) HourChart() template.HTML {
+	var labels []string
+	var bookings []float64
+	for hour, count := range stats.PerHour {
+		labels = append(labels, fmt.Sprintf("%02d", hour))
+		bookings = append(bookings, float64(count))
+	}
+
+	return chart.Bars(labels,
+		chart.Series{Name: "Reservierte Termine", Color: "#5b8fd9", Values: bookings},
+	)
+}
+
+func newLeadTime(leadTimes []time.Duration) LeadTime {
+	if len(leadTimes) == 0 {
+		return LeadTime{}
+	}
+
+	sort.Slice(leadTimes, func(i, j int) bool { return leadTimes[i] < leadTimes[j] })
+
+	var sum time.Duration
+	for _, d := range leadTimes {
+		sum += d
+	}
+
+	median := leadTimes[len(leadTimes)/2]
+	if len(leadTimes)%2 == 0 {
+		median = (leadTimes[len(leadTimes)/2-1] + leadTimes[len(leadTimes)/2]) / 2
+	}
+
+	return LeadTime{
+		Average: sum / time.Duration(len(leadTimes)),
+		Median:  median,
+		Min:     leadTimes[0],
+		Max:     leadTimes[len(leadTimes)-1],
+	}
+}
+
+// truncateDay returns midnight of the day of `t` in its location
+func truncateDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
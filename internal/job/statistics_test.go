@@ -0,0 +1,46 @@
+package job
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewStatistics(t *testing.T) {
+	from := time.Date(2024, 3, 4, 6, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 3, 6, 6, 0, 0, 0, time.UTC)
+	at := func(day, hour int) time.Time {
+		return time.Date(2024, 3, day, hour, 0, 0, 0, time.UTC)
+	}
+
+	stats := NewStatistics(from, to, []*ApptChange{
+		{Time: at(4, 8), Appointment: at(5, 9), PatientID: 1, IsBooking: true},
+		{Time: at(4, 9), Appointment: at(7, 9), PatientID: 2, IsBooking: true},
+		{Time: at(5, 10), Appointment: at(8, 11), PatientID: 3, IsBooking: true},
+		{Time: at(5, 11), Appointment: at(7, 9), PatientID: 2, PatientName: "Repeat", IsBooking: false},
+		{Time: at(6, 5), Appointment: at(8, 11), PatientID: 2, PatientName: "Repeat", IsBooking: false},
+		{Time: at(6, 5), Appointment: at(8, 11), PatientID: 3, IsBooking: false},
+	})
+
+	assert.Equal(t, 3, stats.Bookings)
+	assert.Equal(t, 3, stats.Cancellations)
+	assert.Equal(t, 0.5, stats.CancellationRate)
+
+	assert.Equal(t, []DayCount{
+		{Day: at(4, 0), Bookings: 2},
+		{Day: at(5, 0), Bookings: 1, Cancellations: 1},
+		{Day: at(6, 0), Cancellations: 2},
+	}, stats.PerDay)
+
+	assert.Equal(t, LeadTime{
+		Average: (25 + 72 + 73) * time.Hour / 3,
+		Median:  72 * time.Hour,
+		Min:     25 * time.Hour,
+		Max:     73 * time.Hour,
+	}, stats.LeadTime)
+
+	assert.Equal(t, SlotCount{Weekday: time.Tuesday, Hour: 9, Count: 1}, stats.BusiestSlots[0])
+	assert.Equal(t, 2, stats.PerHour[9])
+	assert.Equal(t, []PatientCount{{PatientID: 2, PatientName: "Repeat", Count: 2}}, stats.RepeatCancellers)
+}
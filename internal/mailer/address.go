@@ -0,0 +1,26 @@
+package mailer
+
+import (
+	"net/mail"
+	"strings"
+)
+
+// splitAddresses splits a comma separated list of addresses
+func splitAddresses(list string) []string {
+	if parsed, err := mail.ParseAddressList(list); err == nil {
+		addresses := make([]string, 0, len(parsed))
+		for _, address := range parsed {
+			addresses = append(addresses, address.String())
+		}
+		return addresses
+	}
+
+	// let the smtp server decide about addresses net/mail does not understand
+	var addresses []string
+	for _, address := range strings.Split(list, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
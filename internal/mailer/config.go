@@ -10,4 +10,7 @@ type Config struct {
 	From    string
 	To      string
 	Subject string
+
+	// RateLimit is the maximum number of messages per hour, 0 disables the limit
+	RateLimit int
 }
@@ -0,0 +1,441 @@
+package mailer
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// diagnoseTimeout limits each network operation of Diagnose, gomail uses the same default
+const diagnoseTimeout = 10 * time.Second
+
+// steps of Diagnose
+const (
+	StepResolve    = "resolve"
+	StepConnect    = "connect"
+	StepEHLO       = "ehlo"
+	StepTLS        = "tls"
+	StepAuth       = "auth"
+	StepSender     = "sender"
+	StepRecipients = "recipients"
+	StepData       = "data"
+	StepQuit       = "quit"
+)
+
+// extensions lists the smtp extensions reported by Diagnose
+var extensions = []string{"STARTTLS", "AUTH", "SIZE", "8BITMIME", "SMTPUTF8", "PIPELINING", "ENHANCEDSTATUSCODES", "DSN", "CHUNKING"}
+
+// Step is the outcome of a step of Diagnose
+type Step struct {
+	Name string
+	// Details describe the outcome, e.g. the capabilities of the server
+	Details []string
+	Err     error
+	// Hint explains a failure and how to fix it, or warns about a problem of a successful step
+	Hint string
+}
+
+// Diagnose connects to the smtp server of `cfg` the way TextMailer does and sends a test message
+// to the recipients of `cfg`. Every step is passed to `report`, Diagnose stops at the first failed step
+// and returns its error.
+func Diagnose(cfg Config, subject, messageText string, report func(*Step)) error {
+	d := &diagnosis{cfg: cfg, report: report}
+	defer d.close()
+
+	for _, step := range []func() *Step{d.resolve, d.connect, d.ehlo, d.startTLS, d.auth, d.sender, d.recipients} {
+		if err := d.run(step); err != nil {
+			return err
+		}
+	}
+
+	if err := d.run(func() *Step { return d.data(subject, messageText) }); err != nil {
+		return err
+	}
+	return d.run(d.quit)
+}
+
+type diagnosis struct {
+	cfg    Config
+	report func(*Step)
+
+	conn   net.Conn
+	client *smtp.Client
+	// implicit TLS on port 465 like gomail
+	ssl bool
+}
+
+// run reports the outcome of `step` and returns its error
+func (d *diagnosis) run(step func() *Step) error {
+	s := step()
+	if s.Err != nil {
+		if s.Hint == "" {
+			s.Hint = d.hint(s.Name, s.Err)
+		}
+		s.Err = unquote(s.Err)
+	}
+	d.report(s)
+	return s.Err
+}
+
+func (d *diagnosis) close() {
+	if d.client != nil {
+		d.client.Close()
+	} else if d.conn != nil {
+		d.conn.Close()
+	}
+}
+
+func (d *diagnosis) resolve() *Step {
+	s := &Step{Name: StepResolve}
+
+	addrs, err := net.LookupHost(d.cfg.Server)
+	if err != nil {
+		s.Err = err
+		return s
+	}
+	s.Details = []string{fmt.Sprintf("%s resolves to %s", d.cfg.Server, strings.Join(addrs, ", "))}
+	return s
+}
+
+func (d *diagnosis) connect() *Step {
+	s := &Step{Name: StepConnect}
+	addr := net.JoinHostPort(d.cfg.Server, strconv.Itoa(d.cfg.Port))
+
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", addr, diagnoseTimeout)
+	if err != nil {
+		s.Err = err
+		return s
+	}
+	conn.SetDeadline(time.Now().Add(diagnoseTimeout * 3))
+	d.conn = conn
+	s.Details = append(s.Details, fmt.Sprintf("connected to %s in %s", conn.RemoteAddr(), time.Since(start).Round(time.Millisecond)))
+
+	d.ssl = d.cfg.Port == 465
+	if d.ssl {
+		tlsConn := tls.Client(conn, d.tlsConfig())
+		if err := tlsConn.Handshake(); err != nil {
+			s.Err = err
+			return s
+		}
+		d.conn = tlsConn
+		s.Details = append(s.Details, "implicit TLS on port 465")
+		s.Details = append(s.Details, tlsDetails(tlsConn.ConnectionState())...)
+	}
+
+	client, err := smtp.NewClient(d.conn, d.cfg.Server)
+	if err != nil {
+		s.Err = errors.Wrap(err, "no smtp greeting")
+		return s
+	}
+	d.client = client
+	s.Details = append(s.Details, "server sent its greeting")
+	return s
+}
+
+func (d *diagnosis) ehlo() *Step {
+	s := &Step{Name: StepEHLO}
+
+	if err := d.client.Hello("localhost"); err != nil {
+		s.Err = err
+		return s
+	}
+	var capabilities []string
+	for _, ext := range extensions {
+		if ok, param := d.client.Extension(ext); ok {
+			capabilities = append(capabilities, strings.TrimSpace(ext+" "+param))
+		}
+	}
+	if len(capabilities) == 0 {
+		s.Details = []string{"server announces no extensions"}
+		return s
+	}
+	s.Details = []string{"capabilities: " + strings.Join(capabilities, ", ")}
+	return s
+}
+
+func (d *diagnosis) startTLS() *Step {
+	s := &Step{Name: StepTLS}
+
+	if d.ssl {
+		s.Details = []string{"connection is encrypted already"}
+		return s
+	}
+	if ok, _ := d.client.Extension("STARTTLS"); !ok {
+		s.Details = []string{"server offers no STARTTLS"}
+		s.Hint = "messages and the password are sent unencrypted"
+		return s
+	}
+
+	if err := d.client.StartTLS(d.tlsConfig()); err != nil {
+		s.Err = err
+		return s
+	}
+	state, _ := d.client.TLSConnectionState()
+	s.Details = append([]string{"STARTTLS succeeded"}, tlsDetails(state)...)
+	return s
+}
+
+func (d *diagnosis) auth() *Step {
+	s := &Step{Name: StepAuth}
+
+	if d.cfg.User == "" {
+		s.Details = []string{"no USER configured, authentication skipped"}
+		return s
+	}
+
+	ok, mechanisms := d.client.Extension("AUTH")
+	if !ok {
+		s.Details = []string{"server offers no authentication"}
+		s.Hint = "USER is configured but the mailer does not authenticate, the server may reject recipients as relaying"
+		return s
+	}
+	s.Details = append(s.Details, "mechanisms: "+mechanisms)
+
+	// choose the mechanism like gomail
+	var auth smtp.Auth
+	switch {
+	case strings.Contains(mechanisms, "CRAM-MD5"):
+		auth = smtp.CRAMMD5Auth(d.cfg.User, d.cfg.Password)
+		s.Details = append(s.Details, "using CRAM-MD5")
+	case strings.Contains(mechanisms, "LOGIN") && !strings.Contains(mechanisms, "PLAIN"):
+		auth = &loginAuth{d.cfg.User, d.cfg.Password}
+		s.Details = append(s.Details, "using LOGIN")
+	default:
+		auth = smtp.PlainAuth("", d.cfg.User, d.cfg.Password, d.cfg.Server)
+		s.Details = append(s.Details, "using PLAIN")
+	}
+
+	if err := d.client.Auth(auth); err != nil {
+		s.Err = err
+		return s
+	}
+	s.Details = append(s.Details, fmt.Sprintf("authenticated as %s", d.cfg.User))
+	return s
+}
+
+func (d *diagnosis) sender() *Step {
+	s := &Step{Name: StepSender}
+
+	from, err := mail.ParseAddress(d.cfg.From)
+	if err != nil {
+		s.Err = errors.Wrapf(err, "invalid FROM %q", d.cfg.From)
+		s.Hint = "FROM must be an address like \"emed-mailer <mailer@example.com>\""
+		return s
+	}
+	if err := d.client.Mail(from.Address); err != nil {
+		s.Err = err
+		return s
+	}
+	s.Details = []string{fmt.Sprintf("%s accepted", from.Address)}
+	return s
+}
+
+func (d *diagnosis) recipients() *Step {
+	s := &Step{Name: StepRecipients}
+
+	recipients := splitAddresses(d.cfg.To)
+	if len(recipients) == 0 {
+		s.Err = errors.New("no recipients")
+		s.Hint = "set TO"
+		return s
+	}
+
+	rejected := 0
+	for _, recipient := range recipients {
+		address := recipient
+		if parsed, err := mail.ParseAddress(recipient); err == nil {
+			address = parsed.Address
+		}
+
+		if err := d.client.Rcpt(address); err != nil {
+			rejected++
+			s.Details = append(s.Details, fmt.Sprintf("%s rejected: %v", address, unquote(err)))
+			if s.Hint == "" {
+				s.Hint = d.hint(StepRecipients, err)
+			}
+			continue
+		}
+		s.Details = append(s.Details, fmt.Sprintf("%s accepted", address))
+	}
+
+	if rejected > 0 {
+		s.Err = errors.Errorf("%d of %d recipients rejected", rejected, len(recipients))
+	}
+	return s
+}
+
+func (d *diagnosis) data(subject, messageText string) *Step {
+	s := &Step{Name: StepData}
+
+	w, err := d.client.Data()
+	if err != nil {
+		s.Err = err
+		return s
+	}
+
+	msg := newMessage(d.cfg, "", subject, "text/plain", messageText)
+	if _, err := msg.WriteTo(w); err != nil {
+		s.Err = err
+		return s
+	}
+	if err := w.Close(); err != nil {
+		s.Err = err
+		return s
+	}
+	s.Details = []string{"message accepted for delivery"}
+	return s
+}
+
+func (d *diagnosis) quit() *Step {
+	s := &Step{Name: StepQuit}
+
+	if err := d.client.Quit(); err != nil {
+		s.Err = err
+		return s
+	}
+	d.client = nil
+	s.Details = []string{"connection closed"}
+	return s
+}
+
+func (d *diagnosis) tlsConfig() *tls.Config {
+	return &tls.Config{ServerName: d.cfg.Server}
+}
+
+// hint explains the error `err` of step `step`
+func (d *diagnosis) hint(step string, err error) string {
+	var dnsErr *net.DNSError
+	var recordErr tls.RecordHeaderError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var certErr x509.CertificateInvalidError
+	var protoErr *textproto.Error
+	var netErr net.Error
+
+	switch {
+	case errors.As(err, &dnsErr):
+		return fmt.Sprintf("SERVER %q can not be resolved, check the name and the DNS settings of this host", d.cfg.Server)
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return fmt.Sprintf("nothing accepts connections on port %d, check PORT: 587 is submission with STARTTLS, 465 implicit TLS, 25 plain smtp", d.cfg.Port)
+	case errors.As(err, &recordErr):
+		return fmt.Sprintf("the server does not speak TLS on port %d, port 465 implies TLS, use 587 or 25 for STARTTLS", d.cfg.Port)
+	case errors.As(err, &authorityErr):
+		return "the certificate of the server is not signed by a trusted authority, install the CA certificate on this host"
+	case errors.As(err, &hostnameErr):
+		return fmt.Sprintf("the certificate of the server is not valid for %q, set SERVER to a name of the certificate", d.cfg.Server)
+	case errors.As(err, &certErr):
+		return "the certificate of the server is invalid, e.g. expired"
+	case errors.As(err, &protoErr):
+		return d.protocolHint(step, protoErr)
+	case errors.As(err, &netErr) && netErr.Timeout(), errors.Is(err, os.ErrDeadlineExceeded):
+		return fmt.Sprintf("no answer within %s, a firewall may block port %d", diagnoseTimeout, d.cfg.Port)
+	case step == StepAuth && strings.Contains(err.Error(), "unencrypted connection"):
+		return "the server offers PLAIN authentication without TLS, the password would be sent unencrypted, enable STARTTLS on the server"
+	}
+	return ""
+}
+
+// protocolHint explains an error response of the smtp server
+func (d *diagnosis) protocolHint(step string, err *textproto.Error) string {
+	if err.Code == 421 {
+		return "the server closed the connection, it may be overloaded or block this host"
+	}
+
+	switch step {
+	case StepAuth:
+		switch err.Code {
+		case 534:
+			return "the provider requires a different login, e.g. an app password or OAuth"
+		case 530, 535:
+			return "USER and PASSWORD were rejected"
+		case 538:
+			return "the server requires encryption for authentication"
+		}
+	case StepSender:
+		if err.Code >= 500 {
+			return fmt.Sprintf("FROM %q is not accepted, many servers only allow the address of USER", d.cfg.From)
+		}
+	case StepRecipients:
+		if strings.Contains(strings.ToLower(err.Msg), "relay") || err.Code == 554 {
+			return "relaying denied, set USER and PASSWORD or allow this host to relay"
+		}
+		switch err.Code {
+		case 530:
+			return "the server requires authentication, set USER and PASSWORD"
+		case 550, 551, 553:
+			return "the recipient is unknown or not allowed, check TO"
+		case 450, 451, 452:
+			return "the recipient is temporarily unavailable, e.g. greylisting, try again later"
+		}
+	case StepData:
+		switch err.Code {
+		case 552:
+			return "the message is too large"
+		case 550, 554:
+			return "the message was rejected by a content or spam policy"
+		}
+	}
+
+	if err.Code >= 400 && err.Code < 500 {
+		return "temporary failure, try again later"
+	}
+	return ""
+}
+
+// unquote returns error responses of the smtp server as sent, textproto quotes them
+func unquote(err error) error {
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		return errors.Errorf("%03d %s", protoErr.Code, protoErr.Msg)
+	}
+	return err
+}
+
+// tlsDetails describes a TLS connection
+func tlsDetails(state tls.ConnectionState) []string {
+	details := []string{fmt.Sprintf("%s, %s", tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite))}
+	if len(state.PeerCertificates) > 0 {
+		cert := state.PeerCertificates[0]
+		details = append(details, fmt.Sprintf("certificate %s issued by %s, valid until %s",
+			cert.Subject.CommonName, cert.Issuer.CommonName, cert.NotAfter.Format("2006-01-02")))
+	}
+	return details
+}
+
+// loginAuth implements the LOGIN mechanism, which net/smtp lacks
+type loginAuth struct {
+	username, password string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && server.Name != "localhost" && server.Name != "127.0.0.1" && server.Name != "::1" {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	}
+	return nil, errors.Errorf("unexpected server challenge %q", fromServer)
+}
@@ -0,0 +1,125 @@
+package mailer
+
+import (
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSMTP serves a single smtp session, recipients starting with "unknown" are rejected
+func fakeSMTP(t *testing.T) (string, int) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		text := textproto.NewConn(conn)
+		text.PrintfLine("220 fake ESMTP")
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+
+			switch {
+			case cmd == "EHLO":
+				text.PrintfLine("250-fake\r\n250-AUTH PLAIN\r\n250 SIZE 1000000")
+			case cmd == "AUTH":
+				text.PrintfLine("235 2.7.0 authenticated")
+			case cmd == "MAIL":
+				text.PrintfLine("250 2.1.0 ok")
+			case cmd == "RCPT" && strings.Contains(line, "<unknown"):
+				text.PrintfLine("550 5.1.1 no such user")
+			case cmd == "RCPT":
+				text.PrintfLine("250 2.1.5 ok")
+			case cmd == "DATA":
+				text.PrintfLine("354 go ahead")
+				if _, err := text.ReadDotBytes(); err != nil {
+					return
+				}
+				text.PrintfLine("250 2.0.0 queued")
+			case cmd == "QUIT":
+				text.PrintfLine("221 bye")
+				return
+			default:
+				text.PrintfLine("502 unknown command")
+			}
+		}
+	}()
+
+	addr := l.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port
+}
+
+func diagnose(cfg Config) (map[string]*Step, error) {
+	steps := map[string]*Step{}
+	err := Diagnose(cfg, "test", "test message", func(s *Step) {
+		steps[s.Name] = s
+	})
+	return steps, err
+}
+
+func TestDiagnose(t *testing.T) {
+	server, port := fakeSMTP(t)
+
+	steps, err := diagnose(Config{
+		Server:   server,
+		Port:     port,
+		User:     "user",
+		Password: "secret",
+		From:     "emed-mailer <mailer@example.com>",
+		To:       "practice@example.com",
+	})
+
+	assert.NoError(t, err)
+	assert.Contains(t, steps[StepEHLO].Details[0], "AUTH PLAIN")
+	// the fake server offers no STARTTLS
+	assert.NotEmpty(t, steps[StepTLS].Hint)
+	assert.Contains(t, steps[StepAuth].Details, "using PLAIN")
+	assert.NoError(t, steps[StepData].Err)
+	assert.NoError(t, steps[StepQuit].Err)
+}
+
+func TestDiagnose_RejectedRecipient(t *testing.T) {
+	server, port := fakeSMTP(t)
+
+	steps, err := diagnose(Config{
+		Server: server,
+		Port:   port,
+		From:   "mailer@example.com",
+		To:     "practice@example.com, unknown@example.com",
+	})
+
+	assert.Error(t, err)
+	if assert.Contains(t, steps, StepRecipients) {
+		assert.Equal(t, []string{"practice@example.com accepted", "unknown@example.com rejected: 550 5.1.1 no such user"}, steps[StepRecipients].Details)
+		assert.Contains(t, steps[StepRecipients].Hint, "check TO")
+	}
+	assert.NotContains(t, steps, StepData)
+}
+
+func TestDiagnose_ConnectionRefused(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	steps, err := diagnose(Config{Server: "127.0.0.1", Port: port})
+
+	assert.Error(t, err)
+	assert.Contains(t, steps[StepConnect].Hint, "check PORT")
+}
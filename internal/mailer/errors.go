@@ -1,6 +1,10 @@
 package mailer
 
-import "github.com/pkg/errors"
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
 
 type notRunning interface {
 	NotRunning() bool
@@ -51,3 +55,30 @@ func (err *alreadyRunningError) Error() string {
 func (err *alreadyRunningError) AlreadyRunning() bool {
 	return true
 }
+
+type rateLimited interface {
+	RateLimited() bool
+}
+
+// IsRateLimited checks if the error cause is a rateLimited error
+// returned if more messages are sent than allowed by the configured rate limit
+func IsRateLimited(err error) bool {
+	rl, ok := errors.Cause(err).(rateLimited)
+	return ok && rl.RateLimited()
+}
+
+type rateLimitedError struct {
+	limit int
+}
+
+func newRateLimitedError(limit int) error {
+	return &rateLimitedError{limit}
+}
+
+func (err *rateLimitedError) Error() string {
+	return fmt.Sprintf("mail rate limit of %d messages per hour exceeded", err.limit)
+}
+
+func (err *rateLimitedError) RateLimited() bool {
+	return true
+}
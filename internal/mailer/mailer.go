@@ -1,10 +1,14 @@
 package mailer
 
 import (
+	"io"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/emed-appts/emed-mailer/internal/metrics"
+
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog/log"
 	gomail "gopkg.in/mail.v2"
 )
@@ -19,9 +23,19 @@ var (
 // TextMailer implements Mailer interface
 // it runs a daemon waiting for text messages to send to a predefined address
 type TextMailer struct {
+	cfgMu    sync.RWMutex
 	cfg      Config
-	messages chan *gomail.Message
+	messages chan *outgoing
+	done     chan struct{}
 	running  bool
+	limiter  rateLimiter
+}
+
+// outgoing is a queued message
+type outgoing struct {
+	msg *gomail.Message
+	// result receives the outcome of sending the message if set
+	result chan error
 }
 
 // New returns a Mailer implementation
@@ -44,8 +58,9 @@ func (mailer *TextMailer) Run(stop <-chan struct{}) error {
 		return newAlreadyRunningError()
 	}
 
-	// create fresh channel
-	mailer.messages = make(chan *gomail.Message)
+	// create fresh channels
+	mailer.messages = make(chan *outgoing)
+	mailer.done = make(chan struct{})
 	go mailer.daemon(stop)
 	// set running state true
 	atomic.StoreUint32(&running, 1)
@@ -56,61 +71,177 @@ func (mailer *TextMailer) Run(stop <-chan struct{}) error {
 	return nil
 }
 
+// Done returns a channel which is closed when the daemon stopped
+// messages sent before stopping the daemon have been delivered by then
+func (mailer *TextMailer) Done() <-chan struct{} {
+	return mailer.done
+}
+
+// Reconfigure replaces the mailer settings
+// an open connection to the smtp server is closed before the next message is sent
+func (mailer *TextMailer) Reconfigure(cfg Config) {
+	mailer.cfgMu.Lock()
+	mailer.cfg = cfg
+	mailer.cfgMu.Unlock()
+}
+
+// Check dials the smtp server with the current settings and closes the connection again
+// it does not send a message, so it can be used by health checks
+func (mailer *TextMailer) Check() error {
+	cfg := mailer.config()
+
+	dialer := gomail.NewDialer(cfg.Server, cfg.Port, cfg.User, cfg.Password)
+	s, err := dialer.Dial()
+	if err != nil {
+		return errors.Wrap(err, "could not dial smtp server")
+	}
+	return errors.Wrap(s.Close(), "could not close connection to smtp server")
+}
+
+// config returns the current mailer settings
+func (mailer *TextMailer) config() Config {
+	mailer.cfgMu.RLock()
+	defer mailer.cfgMu.RUnlock()
+
+	return mailer.cfg
+}
+
 // SendMessage prepares new messages and sends them
 // Caller is responsible for proper escaping of message in case of e.g. HTML
 func (mailer *TextMailer) SendMessage(contentType, messageText string) error {
+	return mailer.send("", "", contentType, messageText)
+}
+
+// SendMessageTo prepares a message to other recipients with another subject and sends it
+// empty values fall back to the configured ones
+func (mailer *TextMailer) SendMessageTo(to, subject, contentType, messageText string) error {
+	return mailer.send(to, subject, contentType, messageText)
+}
+
+// DeliverMessageTo sends a message like SendMessageTo, but waits until the smtp server accepted it
+// the error tells if the message was delivered, e.g. to record notifications only once they are sent
+func (mailer *TextMailer) DeliverMessageTo(to, subject, contentType, messageText string) error {
+	result := make(chan error, 1)
+	if err := mailer.queue(to, subject, contentType, messageText, result); err != nil {
+		return err
+	}
+	return <-result
+}
+
+// send queues a message, empty recipients or subject are taken from the config
+func (mailer *TextMailer) send(to, subject, contentType, messageText string) error {
+	return mailer.queue(to, subject, contentType, messageText, nil)
+}
+
+// queue passes a message to the daemon, which reports the outcome to `result` if set
+func (mailer *TextMailer) queue(to, subject, contentType, messageText string, result chan error) error {
 	if !mailer.running {
 		return newNotRunningError()
 	}
 
-	// prepare message
-	msg := gomail.NewMessage()
-	msg.SetHeader("From", mailer.cfg.From)
-	msg.SetHeader("To", mailer.cfg.To)
-	msg.SetHeader("Subject", mailer.cfg.Subject)
-	msg.SetBody(contentType, messageText)
+	cfg := mailer.config()
+	if !mailer.limiter.allow(cfg.RateLimit, time.Now()) {
+		return newRateLimitedError(cfg.RateLimit)
+	}
+
+	msg := newMessage(cfg, to, subject, contentType, messageText)
 
-	mailer.messages <- msg
+	metrics.QueueDepth.Inc()
+	mailer.messages <- &outgoing{msg, result}
 	return nil
 }
 
 // daemon listens for messages on the channel and sends them
 func (mailer *TextMailer) daemon(stop <-chan struct{}) {
-	// prepare smpt dialer
-	dialer := gomail.NewDialer(mailer.cfg.Server, mailer.cfg.Port, mailer.cfg.User, mailer.cfg.Password)
-
 	var s gomail.SendCloser
-	var err error
+	// settings of the open connection
+	var dialed Config
 	// dialer status: is open or closed
 	open := false
+
+	dial := func(cfg Config) error {
+		// prepare smpt dialer
+		dialer := gomail.NewDialer(cfg.Server, cfg.Port, cfg.User, cfg.Password)
+
+		start := time.Now()
+		sender, err := dialer.Dial()
+		if err != nil {
+			metrics.DialDuration.WithLabelValues("failed").Observe(time.Since(start).Seconds())
+			return err
+		}
+		metrics.DialDuration.WithLabelValues("success").Observe(time.Since(start).Seconds())
+
+		s, dialed, open = sender, cfg, true
+		return nil
+	}
+	hangUp := func() {
+		if !open {
+			return
+		}
+		if err := s.Close(); err != nil {
+			log.Error().
+				Err(err).
+				Msg("could not close sender")
+		}
+		open = false
+	}
+
 	for {
 		select {
-		case msg := <-mailer.messages:
+		case out := <-mailer.messages:
+			metrics.QueueDepth.Dec()
+			msg := out.msg
+			report := func(err error) {
+				if out.result != nil {
+					out.result <- err
+				}
+			}
+
+			// reconnect if the settings changed
+			cfg := mailer.config()
+			if open && dialed != cfg {
+				hangUp()
+			}
 			if !open {
-				if s, err = dialer.Dial(); err != nil {
+				if err := dial(cfg); err != nil {
 					log.Error().
 						Err(err).
 						Msg("could not dial smtp server")
+					metrics.MailsFailed.Inc()
+					report(errors.Wrap(err, "could not dial smtp server"))
+					continue
 				}
-				open = true
 			}
-			if err := gomail.Send(s, msg); err != nil {
+
+			accepted, err := sendMessage(s, msg)
+			if err != nil && !accepted {
+				// the server may have dropped the connection, retry once over a new one
+				// not after the server accepted DATA, it may have delivered the message then
+				hangUp()
+				metrics.MailsRetried.Inc()
+				if err = dial(cfg); err == nil {
+					accepted, err = sendMessage(s, msg)
+				}
+			}
+			if err != nil {
+				hangUp()
 				log.Error().
 					Err(err).
+					Bool("dataAccepted", accepted).
 					Msg("could not send mail")
+				metrics.MailsFailed.Inc()
+				report(errors.Wrap(err, "could not send mail"))
+				continue
 			}
+			metrics.MailsSent.Inc()
+			report(nil)
 			// Close the connection to the SMTP server if no email was sent in
 			// the last 30 seconds.
 		case <-time.After(30 * time.Second):
-			if open {
-				if err := s.Close(); err != nil {
-					log.Error().
-						Err(err).
-						Msg("could not close sender")
-				}
-				open = false
-			}
+			hangUp()
 		case <-stop:
+			hangUp()
+
 			runMu.Lock()
 
 			// set running state false
@@ -118,8 +249,32 @@ func (mailer *TextMailer) daemon(stop <-chan struct{}) {
 			mailer.running = false
 
 			runMu.Unlock()
+			close(mailer.done)
 			return
 		}
 
 	}
 }
+
+// sendMessage sends `msg` over `s` and reports if the server accepted the DATA command
+// an error after that leaves open whether the message was delivered
+func sendMessage(s gomail.Sender, msg *gomail.Message) (bool, error) {
+	accepted := false
+	sender := gomail.SendFunc(func(from string, to []string, m io.WriterTo) error {
+		return s.Send(from, to, writerToFunc(func(w io.Writer) (int64, error) {
+			// the message is written once the server accepted DATA
+			accepted = true
+			return m.WriteTo(w)
+		}))
+	})
+
+	err := gomail.Send(sender, msg)
+	return accepted, err
+}
+
+// writerToFunc adapts a function to io.WriterTo
+type writerToFunc func(io.Writer) (int64, error)
+
+func (f writerToFunc) WriteTo(w io.Writer) (int64, error) {
+	return f(w)
+}
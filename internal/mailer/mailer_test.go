@@ -0,0 +1,60 @@
+package mailer
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	gomail "gopkg.in/mail.v2"
+)
+
+func TestSendMessage(t *testing.T) {
+	msg := newMessage(Config{From: "from@example.com", To: "to@example.com"}, "", "Subject", "text/plain", "text")
+
+	// the connection was dropped before DATA, the message can be sent again
+	accepted, err := sendMessage(gomail.SendFunc(func(from string, to []string, m io.WriterTo) error {
+		return errors.New("connection reset")
+	}), msg)
+	assert.Error(t, err)
+	assert.False(t, accepted)
+
+	// the server failed after DATA, it may have delivered the message
+	accepted, err = sendMessage(gomail.SendFunc(func(from string, to []string, m io.WriterTo) error {
+		if _, err := m.WriteTo(io.Discard); err != nil {
+			return err
+		}
+		return errors.New("451 local error")
+	}), msg)
+	assert.Error(t, err)
+	assert.True(t, accepted)
+}
+
+func TestTextMailer_DeliverMessageTo(t *testing.T) {
+	server, port := fakeSMTP(t)
+	cfg := Config{Server: server, Port: port, From: "mailer@example.com", To: "practice@example.com"}
+
+	m := New(cfg)
+	stop := make(chan struct{})
+	if err := m.Run(stop); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		close(stop)
+		<-m.Done()
+	}()
+
+	assert.NoError(t, m.DeliverMessageTo("patient@example.com", "Subject", "text/html", "<p>text</p>"))
+
+	// the outcome of the delivery is reported, not just that the message is queued
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Port = l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	m.Reconfigure(cfg)
+
+	assert.Error(t, m.DeliverMessageTo("patient@example.com", "Subject", "text/html", "<p>text</p>"))
+}
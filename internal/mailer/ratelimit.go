@@ -0,0 +1,36 @@
+package mailer
+
+import (
+	"sync"
+	"time"
+)
+
+// rateWindow is the period the rate limit applies to
+const rateWindow = time.Hour
+
+// rateLimiter limits the number of messages within a sliding window
+type rateLimiter struct {
+	mu   sync.Mutex
+	sent []time.Time
+}
+
+// allow reports if another message may be sent and records it
+// a limit of 0 allows any number of messages
+func (l *rateLimiter) allow(limit int, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// forget messages sent before the window
+	i := 0
+	for i < len(l.sent) && !l.sent[i].After(now.Add(-rateWindow)) {
+		i++
+	}
+	l.sent = l.sent[i:]
+
+	if limit > 0 && len(l.sent) >= limit {
+		return false
+	}
+
+	l.sent = append(l.sent, now)
+	return true
+}
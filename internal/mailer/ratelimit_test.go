@@ -0,0 +1,26 @@
+package mailer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter_Allow(t *testing.T) {
+	l := &rateLimiter{}
+	now := time.Now()
+
+	assert.True(t, l.allow(2, now))
+	assert.True(t, l.allow(2, now.Add(time.Minute)))
+	assert.False(t, l.allow(2, now.Add(time.Minute*2)))
+
+	// first message left the window
+	assert.True(t, l.allow(2, now.Add(rateWindow+time.Second)))
+	assert.False(t, l.allow(2, now.Add(rateWindow+time.Second*2)))
+
+	// no limit
+	for i := 0; i < 100; i++ {
+		assert.True(t, l.allow(0, now.Add(rateWindow*3)))
+	}
+}
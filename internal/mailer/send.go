@@ -0,0 +1,44 @@
+package mailer
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+	gomail "gopkg.in/mail.v2"
+)
+
+// Send sends a single message to the recipients of `cfg` over a new connection
+// unlike TextMailer it waits for the smtp server and does not apply the rate limit,
+// so it suits rare messages like alerts, which must not depend on the daemon
+func Send(cfg Config, subject, contentType, messageText string) error {
+	msg := newMessage(cfg, "", subject, contentType, messageText)
+
+	dialer := gomail.NewDialer(cfg.Server, cfg.Port, cfg.User, cfg.Password)
+	return errors.Wrap(dialer.DialAndSend(msg), "could not send mail")
+}
+
+// WriteMessage writes the message TextMailer would send as MIME mail (.eml) to `w`
+// empty recipients or subject are taken from the config
+func WriteMessage(w io.Writer, cfg Config, to, subject, contentType, messageText string) error {
+	msg := newMessage(cfg, to, subject, contentType, messageText)
+
+	_, err := msg.WriteTo(w)
+	return errors.Wrap(err, "could not write mail")
+}
+
+// newMessage prepares a message, empty recipients or subject are taken from the config
+func newMessage(cfg Config, to, subject, contentType, messageText string) *gomail.Message {
+	if to == "" {
+		to = cfg.To
+	}
+	if subject == "" {
+		subject = cfg.Subject
+	}
+
+	msg := gomail.NewMessage()
+	msg.SetHeader("From", cfg.From)
+	msg.SetHeader("To", splitAddresses(to)...)
+	msg.SetHeader("Subject", subject)
+	msg.SetBody(contentType, messageText)
+	return msg
+}
@@ -0,0 +1,111 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace prefixes all metric names
+const namespace = "emed_mailer"
+
+var (
+	registry = prometheus.NewRegistry()
+
+	// JobRuns counts the runs of jobs by job and result
+	JobRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "job_runs_total",
+		Help:      "Runs of jobs by result (success, skipped, failed).",
+	}, []string{"job", "result"})
+
+	// ChangesCollected counts the collected appointment changes by kind
+	ChangesCollected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "changes_collected_total",
+		Help:      "Collected appointment changes by kind (booking, cancellation, unparsed).",
+	}, []string{"kind"})
+
+	// QueryDuration observes the duration of database queries
+	QueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "collector_query_duration_seconds",
+		Help:      "Duration of database queries of the collector.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"query"})
+
+	// RenderDuration observes the duration of rendering templates
+	RenderDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "template_render_duration_seconds",
+		Help:      "Duration of rendering message templates.",
+		Buckets:   []float64{.001, .005, .01, .05, .1, .5, 1},
+	}, []string{"template"})
+
+	// MailsSent counts the mails accepted by the smtp server
+	MailsSent = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mails_sent_total",
+		Help:      "Mails accepted by the smtp server.",
+	})
+
+	// MailsFailed counts the mails which could not be sent, also after a retry
+	MailsFailed = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mails_failed_total",
+		Help:      "Mails which could not be sent, also after a retry.",
+	})
+
+	// MailsRetried counts the mails sent again over a new connection after a failure
+	MailsRetried = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mails_retried_total",
+		Help:      "Mails sent again over a new connection after a failure.",
+	})
+
+	// DialDuration observes the latency of connecting to the smtp server
+	DialDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "smtp_dial_duration_seconds",
+		Help:      "Latency of connecting to the smtp server by result (success, failed).",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"result"})
+
+	// QueueDepth is the number of mails waiting for the mailer daemon
+	QueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "mail_queue_depth",
+		Help:      "Mails waiting for the mailer daemon.",
+	})
+
+	// WebhookEvents counts the requests of booking portals by result
+	WebhookEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_events_total",
+		Help:      "Events posted to the webhook by result (accepted, duplicate, invalid, unauthorized, failed).",
+	}, []string{"result"})
+)
+
+func init() {
+	registry.MustRegister(
+		JobRuns,
+		ChangesCollected,
+		QueryDuration,
+		RenderDuration,
+		MailsSent,
+		MailsFailed,
+		MailsRetried,
+		DialDuration,
+		QueueDepth,
+		WebhookEvents,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Handler returns the handler exposing the metrics in Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,146 @@
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/emed-appts/emed-mailer/internal/metrics"
+
+	"github.com/rs/zerolog/log"
+)
+
+// checkTimeout limits the time of all health checks together
+const checkTimeout = 10 * time.Second
+
+// Check reports the health of a dependency, e.g. the database
+type Check func(context.Context) error
+
+// JobStatus describes a scheduled job and its latest run
+type JobStatus struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Schedule string `json:"schedule"`
+
+	NextRun time.Time `json:"nextRun"`
+	// LastRun is the time of the last successful run
+	LastRun time.Time `json:"lastRun"`
+	// LastAttempt is the time of the latest run, nil before the first run
+	LastAttempt *time.Time `json:"lastAttempt,omitempty"`
+	Result      string     `json:"result,omitempty"`
+	Count       int        `json:"count"`
+	Error       string     `json:"error,omitempty"`
+	// Failures counts the failed runs since the last successful run
+	Failures int `json:"failures"`
+}
+
+// StatusFunc lists the status of all scheduled jobs
+type StatusFunc func() []JobStatus
+
+// health is the response of /healthz
+type health struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+type handler struct {
+	checks map[string]Check
+	status StatusFunc
+}
+
+// New returns the handler of the status, health and metrics endpoints
+// /healthz runs all `checks` and responds 503 if one of them fails
+// /status lists the jobs returned by `status`
+// /metrics exposes the metrics in Prometheus text format
+func New(checks map[string]Check, status StatusFunc) http.Handler {
+	h := &handler{checks, status}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", h.healthz)
+	mux.HandleFunc("/status", h.jobStatus)
+	mux.Handle("/metrics", metrics.Handler())
+	return mux
+}
+
+// healthz runs the checks concurrently
+func (h *handler) healthz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+	defer cancel()
+
+	result := health{
+		Status: "ok",
+		Checks: map[string]string{},
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range h.checks {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+
+			state := "ok"
+			if err := run(ctx, check); err != nil {
+				state = err.Error()
+			}
+
+			mu.Lock()
+			result.Checks[name] = state
+			if state != "ok" {
+				result.Status = "error"
+			}
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+
+	status := http.StatusOK
+	if result.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, result)
+}
+
+// run executes `check`, checks not returning in time fail
+func run(ctx context.Context, check Check) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- check(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// jobStatus lists the jobs ordered by name
+func (h *handler) jobStatus(w http.ResponseWriter, r *http.Request) {
+	jobs := h.status()
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Name < jobs[j].Name
+	})
+
+	writeJSON(w, http.StatusOK, struct {
+		Jobs []JobStatus `json:"jobs"`
+	}{jobs})
+}
+
+// writeJSON writes `v` as JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Error().
+			Err(err).
+			Msg("could not write response")
+	}
+}
@@ -0,0 +1,80 @@
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthz(t *testing.T) {
+	ok := func(context.Context) error { return nil }
+	failing := func(context.Context) error { return errors.New("connection refused") }
+
+	for _, tc := range []struct {
+		name   string
+		checks map[string]Check
+		status int
+		result health
+	}{
+		{
+			name:   "healthy",
+			checks: map[string]Check{"db": ok, "smtp": ok},
+			status: http.StatusOK,
+			result: health{Status: "ok", Checks: map[string]string{"db": "ok", "smtp": "ok"}},
+		},
+		{
+			name:   "smtp unavailable",
+			checks: map[string]Check{"db": ok, "smtp": failing},
+			status: http.StatusServiceUnavailable,
+			result: health{Status: "error", Checks: map[string]string{"db": "ok", "smtp": "connection refused"}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			New(tc.checks, nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+			var result health
+			assert.NoError(t, json.NewDecoder(rec.Body).Decode(&result))
+			assert.Equal(t, tc.status, rec.Code)
+			assert.Equal(t, tc.result, result)
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	lastRun := time.Date(2026, 10, 19, 6, 0, 0, 0, time.UTC)
+
+	rec := httptest.NewRecorder()
+	New(nil, func() []JobStatus {
+		return []JobStatus{
+			{Name: "weekly", Type: "report", Schedule: "0 0 7 * * FRI", LastRun: lastRun},
+			{Name: "daily", Type: "changes", Schedule: "0 0 6 * * *", LastRun: lastRun, LastAttempt: &lastRun, Result: "success", Count: 3},
+		}
+	}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+
+	var result struct {
+		Jobs []JobStatus
+	}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&result))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	if assert.Len(t, result.Jobs, 2) {
+		assert.Equal(t, "daily", result.Jobs[0].Name)
+		assert.Equal(t, 3, result.Jobs[0].Count)
+		assert.Nil(t, result.Jobs[1].LastAttempt)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	rec := httptest.NewRecorder()
+	New(nil, nil).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "emed_mailer_mails_sent_total 0")
+	assert.Contains(t, rec.Body.String(), "emed_mailer_mail_queue_depth 0")
+}
@@ -0,0 +1,31 @@
+package notifier
+
+import (
+	"github.com/emed-appts/emed-mailer/internal/job"
+)
+
+// Sender sends mails, implemented by mailer.TextMailer
+type Sender interface {
+	SendMessageTo(string, string, string, string) error
+}
+
+type emailChannel struct {
+	sender  Sender
+	to      string
+	subject string
+}
+
+// NewEmail returns a channel sending messages as mail to `to` with `subject`
+// the subject falls back to the one of the message, empty values fall back to the mailer settings
+func NewEmail(sender Sender, to, subject string) job.Notifier {
+	return &emailChannel{sender, to, subject}
+}
+
+// Notify sends the message as mail
+func (c *emailChannel) Notify(message *job.Message) error {
+	subject := c.subject
+	if subject == "" {
+		subject = message.Subject
+	}
+	return c.sender.SendMessageTo(c.to, subject, message.ContentType, message.Body)
+}
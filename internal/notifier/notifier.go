@@ -0,0 +1,228 @@
+package notifier
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/emed-appts/emed-mailer/internal/job"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// requestTimeout limits requests to http based channels
+const requestTimeout = 10 * time.Second
+
+// httpClient is shared by all http based channels
+var httpClient = &http.Client{Timeout: requestTimeout}
+
+// Channel is a named notification channel
+type Channel struct {
+	Name     string
+	Notifier job.Notifier
+}
+
+// RecipientsError is returned by a channel which delivered a message to some of its recipients only
+type RecipientsError struct {
+	// Recipients lists the recipients the message was not delivered to
+	Recipients []string
+	err        error
+}
+
+func (e *RecipientsError) Error() string {
+	return e.err.Error()
+}
+
+// recipientNotifier is a channel able to send a message to some of its recipients
+type recipientNotifier interface {
+	NotifyRecipients(message *job.Message, recipients []string) error
+}
+
+// multiNotifier sends messages over several channels
+type multiNotifier struct {
+	channels []Channel
+	outbox   *Outbox
+}
+
+// NewMulti returns a Notifier sending messages over all `channels`
+// messages which only some channels delivered are queued in `outbox` for the failed ones,
+// a channel delivering to some of its recipients only queues the message for the failed recipients,
+// without an outbox a failing channel fails the message
+func NewMulti(outbox *Outbox, channels ...Channel) job.Notifier {
+	return &multiNotifier{channels, outbox}
+}
+
+// Notify sends the message over every channel, also if sending over one of them fails
+// messages queued in the outbox are sent first, a channel failing to deliver them is skipped
+// it fails if no channel delivered the message, so the job sends it again
+func (n *multiNotifier) Notify(message *job.Message) error {
+	var (
+		queued  []*Pending
+		blocked = map[string]bool{}
+	)
+	if n.outbox != nil {
+		pending, err := n.outbox.Load()
+		if err != nil {
+			return err
+		}
+		queued = n.resend(pending, blocked)
+	}
+
+	var failed, delivered []string
+	for _, c := range n.channels {
+		if blocked[c.Name] {
+			failed = append(failed, fmt.Sprintf("%s: queued messages are pending", c.Name))
+			queued = append(queued, &Pending{Channel: c.Name, Message: message, Queued: time.Now()})
+			continue
+		}
+		if err := c.Notifier.Notify(message); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", c.Name, err))
+
+			var partial *RecipientsError
+			if errors.As(err, &partial) {
+				queued = append(queued, &Pending{Channel: c.Name, Message: message, Recipients: partial.Recipients, Queued: time.Now()})
+				delivered = append(delivered, c.Name)
+				continue
+			}
+			queued = append(queued, &Pending{Channel: c.Name, Message: message, Queued: time.Now()})
+			continue
+		}
+		delivered = append(delivered, c.Name)
+	}
+
+	if len(failed) == 0 {
+		return n.save(queued)
+	}
+
+	err := errors.Errorf("%d of %d channels failed: %s", len(failed), len(n.channels), strings.Join(failed, "; "))
+	if n.outbox == nil || len(delivered) == 0 {
+		// the message is sent again as a whole
+		if n.outbox != nil {
+			queued = queued[:len(queued)-len(failed)]
+			if saveErr := n.save(queued); saveErr != nil {
+				log.Error().Err(saveErr).Msg("could not save outbox")
+			}
+		}
+		return err
+	}
+
+	log.Warn().
+		Err(err).
+		Strs("delivered", delivered).
+		Msg("message is queued for the failed channels")
+	return n.save(queued)
+}
+
+// resend sends the `pending` messages again in the order they were queued
+// it returns the messages still pending and marks the channels failing to deliver them in `blocked`
+func (n *multiNotifier) resend(pending []*Pending, blocked map[string]bool) []*Pending {
+	channels := map[string]job.Notifier{}
+	for _, c := range n.channels {
+		channels[c.Name] = c.Notifier
+	}
+
+	var queued []*Pending
+	for _, p := range pending {
+		notifier, ok := channels[p.Channel]
+		switch {
+		case !ok:
+			log.Warn().
+				Str("channel", p.Channel).
+				Str("subject", p.Message.Subject).
+				Msg("dropped queued message, the channel is no longer configured")
+		case time.Since(p.Queued) > outboxRetention:
+			log.Error().
+				Str("channel", p.Channel).
+				Str("subject", p.Message.Subject).
+				Time("queued", p.Queued).
+				Msg("dropped queued message, it could not be delivered in time")
+		case blocked[p.Channel]:
+			queued = append(queued, p)
+		default:
+			if err := notifyPending(notifier, p); err != nil {
+				log.Debug().Err(err).Str("channel", p.Channel).Msg("could not send queued message")
+
+				var partial *RecipientsError
+				if errors.As(err, &partial) {
+					p.Recipients = partial.Recipients
+				}
+				blocked[p.Channel] = true
+				queued = append(queued, p)
+			}
+		}
+	}
+	return queued
+}
+
+// notifyPending sends the pending message to its failed recipients if known, otherwise to all
+func notifyPending(notifier job.Notifier, p *Pending) error {
+	if rn, ok := notifier.(recipientNotifier); ok && len(p.Recipients) > 0 {
+		return rn.NotifyRecipients(p.Message, p.Recipients)
+	}
+	return notifier.Notify(p.Message)
+}
+
+// save stores the `queued` messages in the outbox
+func (n *multiNotifier) save(queued []*Pending) error {
+	if n.outbox == nil {
+		return nil
+	}
+	return n.outbox.Save(queued)
+}
+
+// postJSON posts `payload` encoded as JSON to `target`
+// `token` is sent as bearer token if set
+func postJSON(target, token string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return errors.Wrap(err, "could not encode payload")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(stripURL(err), "could not create request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(stripURL(err), "request failed")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return errors.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(detail)))
+	}
+
+	return nil
+}
+
+// stripURL removes the url from errors of net/url and net/http, it may contain credentials
+func stripURL(err error) error {
+	if urlErr, ok := err.(*url.Error); ok {
+		return urlErr.Err
+	}
+	return err
+}
+
+// truncate shortens `text` to `max` characters, 0 keeps the text
+func truncate(text string, max int) string {
+	runes := []rune(text)
+	if max <= 0 || len(runes) <= max {
+		return text
+	}
+	if max == 1 {
+		return "…"
+	}
+	return string(runes[:max-1]) + "…"
+}
@@ -0,0 +1,208 @@
+package notifier
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/emed-appts/emed-mailer/internal/job"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// stub records the JSON payloads posted to it
+type stub struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	payloads []map[string]string
+	headers  []http.Header
+}
+
+func newStub(status int) *stub {
+	s := &stub{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload := map[string]string{}
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+
+		s.mu.Lock()
+		s.payloads = append(s.payloads, payload)
+		s.headers = append(s.headers, r.Header)
+		s.mu.Unlock()
+
+		w.WriteHeader(status)
+	}))
+	return s
+}
+
+var message = &job.Message{
+	Subject:     "Subject",
+	ContentType: "text/html",
+	Body:        "<p>Body</p>",
+	Text:        "eTermin Buchungen/Storni: 1",
+}
+
+func TestSMS(t *testing.T) {
+	s := newStub(http.StatusOK)
+	defer s.Close()
+
+	n := NewSMS(SMSConfig{
+		URL:       s.URL,
+		Token:     "secret",
+		To:        "+491111, +492222",
+		ToField:   "recipient",
+		TextField: "message",
+		MaxLength: 10,
+	})
+	assert.NoError(t, n.Notify(message))
+
+	if assert.Len(t, s.payloads, 2) {
+		assert.Equal(t, map[string]string{"recipient": "+491111", "message": "eTermin B…"}, s.payloads[0])
+		assert.Equal(t, "+492222", s.payloads[1]["recipient"])
+		assert.Equal(t, "Bearer secret", s.headers[0].Get("Authorization"))
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	s := newStub(http.StatusOK)
+	defer s.Close()
+
+	assert.NoError(t, NewWebhook(s.URL, 0).Notify(message))
+
+	if assert.Len(t, s.payloads, 1) {
+		assert.Equal(t, map[string]string{"text": message.Text}, s.payloads[0])
+		assert.Empty(t, s.headers[0].Get("Authorization"))
+	}
+}
+
+func TestMatrix(t *testing.T) {
+	s := newStub(http.StatusOK)
+	defer s.Close()
+
+	assert.NoError(t, NewMatrix(s.URL, "", "emed-mailer", 0).Notify(message))
+
+	if assert.Len(t, s.payloads, 1) {
+		assert.Equal(t, map[string]string{"text": message.Text, "username": "emed-mailer"}, s.payloads[0])
+	}
+}
+
+func TestMulti(t *testing.T) {
+	failing := newStub(http.StatusInternalServerError)
+	defer failing.Close()
+	ok := newStub(http.StatusNoContent)
+	defer ok.Close()
+
+	n := NewMulti(nil,
+		Channel{Name: "teams", Notifier: NewWebhook(failing.URL, 0)},
+		Channel{Name: "matrix", Notifier: NewMatrix(ok.URL, "", "emed-mailer", 0)},
+	)
+	err := n.Notify(message)
+
+	// channels after a failing one are notified anyway
+	if assert.Error(t, err) {
+		assert.True(t, strings.Contains(err.Error(), "1 of 2 channels failed: teams: unexpected status 500"), err.Error())
+	}
+	assert.Len(t, failing.payloads, 1)
+	assert.Len(t, ok.payloads, 1)
+}
+
+func TestMulti_Outbox(t *testing.T) {
+	failing := newStub(http.StatusInternalServerError)
+	defer failing.Close()
+	ok := newStub(http.StatusNoContent)
+	defer ok.Close()
+
+	outbox := NewOutbox(t.TempDir(), "changedappts")
+	channels := func(teams string) []Channel {
+		return []Channel{
+			{Name: "teams", Notifier: NewWebhook(teams, 0)},
+			{Name: "matrix", Notifier: NewMatrix(ok.URL, "", "emed-mailer", 0)},
+		}
+	}
+
+	// the message is queued for the failed channel only
+	assert.NoError(t, NewMulti(outbox, channels(failing.URL)...).Notify(message))
+	pending, err := outbox.Load()
+	if assert.NoError(t, err) && assert.Len(t, pending, 1) {
+		assert.Equal(t, "teams", pending[0].Channel)
+		assert.Equal(t, message, pending[0].Message)
+	}
+
+	// the failed channel keeps the order of its messages
+	second := &job.Message{Subject: "Second", Text: "second"}
+	assert.NoError(t, NewMulti(outbox, channels(failing.URL)...).Notify(second))
+	assert.Len(t, failing.payloads, 2, "the new message is not sent before the queued one")
+	assert.Len(t, ok.payloads, 2)
+	pending, _ = outbox.Load()
+	assert.Len(t, pending, 2)
+
+	// a recovered channel gets the queued messages, the others no duplicates
+	recovered := newStub(http.StatusOK)
+	defer recovered.Close()
+	assert.NoError(t, NewMulti(outbox, channels(recovered.URL)...).Notify(&job.Message{Text: "third"}))
+	if assert.Len(t, recovered.payloads, 3) {
+		assert.Equal(t, message.Text, recovered.payloads[0]["text"])
+		assert.Equal(t, "second", recovered.payloads[1]["text"])
+		assert.Equal(t, "third", recovered.payloads[2]["text"])
+	}
+	assert.Len(t, ok.payloads, 3)
+	pending, err = outbox.Load()
+	assert.NoError(t, err)
+	assert.Empty(t, pending)
+
+	// a message no channel delivered fails, so the job sends it again
+	err = NewMulti(outbox, Channel{Name: "teams", Notifier: NewWebhook(failing.URL, 0)}).Notify(message)
+	assert.Error(t, err)
+	pending, _ = outbox.Load()
+	assert.Empty(t, pending)
+}
+
+func TestMulti_SMSRecipients(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		down     = map[string]bool{"+492222": true}
+		received []string
+	)
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload := map[string]string{}
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if down[payload["to"]] {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		received = append(received, payload["to"]+" "+payload["text"])
+	}))
+	defer gateway.Close()
+
+	outbox := NewOutbox(t.TempDir(), "urgent")
+	sms := Channel{Name: "sms", Notifier: NewSMS(SMSConfig{URL: gateway.URL, To: "+491111, +492222", ToField: "to", TextField: "text"})}
+
+	// the message is queued for the failed recipient only
+	assert.NoError(t, NewMulti(outbox, sms).Notify(&job.Message{Text: "first"}))
+	pending, err := outbox.Load()
+	if assert.NoError(t, err) && assert.Len(t, pending, 1) {
+		assert.Equal(t, "sms", pending[0].Channel)
+		assert.Equal(t, []string{"+492222"}, pending[0].Recipients)
+	}
+
+	// the recovered recipient gets the queued message, the other one no duplicate
+	mu.Lock()
+	down = map[string]bool{}
+	mu.Unlock()
+	assert.NoError(t, NewMulti(outbox, sms).Notify(&job.Message{Text: "second"}))
+	assert.Equal(t, []string{
+		"+491111 first",
+		"+492222 first",
+		"+491111 second",
+		"+492222 second",
+	}, received)
+	pending, err = outbox.Load()
+	assert.NoError(t, err)
+	assert.Empty(t, pending)
+}
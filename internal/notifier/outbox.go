@@ -0,0 +1,76 @@
+package notifier
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"time"
+
+	"github.com/emed-appts/emed-mailer/internal/job"
+
+	"github.com/pkg/errors"
+)
+
+// outboxRetention is the time a queued message is sent again, older ones are dropped
+const outboxRetention = 7 * 24 * time.Hour
+
+// Pending is a message queued for a channel which failed while other channels delivered it
+type Pending struct {
+	Channel string       `json:"channel"`
+	Message *job.Message `json:"message"`
+	// Recipients lists the recipients of the channel the message is sent again to, all if empty
+	Recipients []string `json:"recipients,omitempty"`
+	// Queued is the time the channel failed to deliver the message
+	Queued time.Time `json:"queued"`
+}
+
+// Outbox persists the queued messages of a job in a JSON file
+// so they are sent again after a restart and the channels which delivered them get no duplicates
+type Outbox struct {
+	path string
+}
+
+// NewOutbox returns the outbox `name` stored in directory `root`
+func NewOutbox(root, name string) *Outbox {
+	return &Outbox{
+		path: path.Join(root, name+".outbox.json"),
+	}
+}
+
+// Load returns the queued messages in the order they were queued
+func (o *Outbox) Load() ([]*Pending, error) {
+	content, err := os.ReadFile(o.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read outbox")
+	}
+
+	var pending []*Pending
+	if err := json.Unmarshal(content, &pending); err != nil {
+		return nil, errors.Wrap(err, "could not parse outbox")
+	}
+	return pending, nil
+}
+
+// Save replaces the queued messages, an empty queue removes the file
+func (o *Outbox) Save(pending []*Pending) error {
+	if len(pending) == 0 {
+		if err := os.Remove(o.path); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "could not remove outbox")
+		}
+		return nil
+	}
+
+	content, err := json.MarshalIndent(pending, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "could not encode outbox")
+	}
+
+	tmp := o.path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0666); err != nil {
+		return errors.Wrap(err, "could not write outbox")
+	}
+	return errors.Wrap(os.Rename(tmp, o.path), "could not replace outbox")
+}
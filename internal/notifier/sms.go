@@ -0,0 +1,90 @@
+package notifier
+
+import (
+	"strings"
+
+	"github.com/emed-appts/emed-mailer/internal/job"
+
+	"github.com/pkg/errors"
+)
+
+// SMSConfig struct encapsulate the settings of a http sms gateway
+type SMSConfig struct {
+	// URL receives a JSON object per recipient
+	URL string
+	// Token is sent as bearer token, optional
+	Token string
+	// To lists the phone numbers of the recipients, separated by comma
+	To string
+	// ToField and TextField name the fields of the JSON object
+	ToField   string
+	TextField string
+	// MaxLength truncates the text, 0 disables truncation
+	MaxLength int
+}
+
+type smsChannel struct {
+	cfg SMSConfig
+}
+
+// NewSMS returns a channel sending the text of messages to a generic http sms gateway
+// e.g. {"to": "+4912345", "text": "..."} is posted for every recipient
+func NewSMS(cfg SMSConfig) job.Notifier {
+	return &smsChannel{cfg}
+}
+
+// Notify sends the text of the message to every recipient
+func (c *smsChannel) Notify(message *job.Message) error {
+	return c.NotifyRecipients(message, c.recipients())
+}
+
+// NotifyRecipients sends the text of the message to those of `recipients` which are still configured
+// if only some recipients failed, a *RecipientsError lists them
+func (c *smsChannel) NotifyRecipients(message *job.Message, recipients []string) error {
+	text := truncate(message.Text, c.cfg.MaxLength)
+
+	configured := map[string]bool{}
+	for _, to := range c.recipients() {
+		configured[to] = true
+	}
+
+	var sent int
+	var failed, reasons []string
+	for _, to := range recipients {
+		if !configured[to] {
+			continue
+		}
+
+		payload := map[string]string{
+			c.cfg.ToField:   to,
+			c.cfg.TextField: text,
+		}
+		if err := postJSON(c.cfg.URL, c.cfg.Token, payload); err != nil {
+			failed = append(failed, to)
+			reasons = append(reasons, to+": "+err.Error())
+			continue
+		}
+		sent++
+	}
+
+	if len(failed) == 0 {
+		return nil
+	}
+
+	err := errors.Errorf("sms to %d recipient(s) failed: %s", len(failed), strings.Join(reasons, "; "))
+	if sent > 0 {
+		return &RecipientsError{Recipients: failed, err: err}
+	}
+	return err
+}
+
+// recipients returns the configured phone numbers
+func (c *smsChannel) recipients() []string {
+	var recipients []string
+	for _, to := range strings.Split(c.cfg.To, ",") {
+		if to = strings.TrimSpace(to); to != "" {
+			recipients = append(recipients, to)
+		}
+	}
+	return recipients
+}
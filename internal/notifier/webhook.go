@@ -0,0 +1,44 @@
+package notifier
+
+import (
+	"github.com/emed-appts/emed-mailer/internal/job"
+)
+
+type webhookChannel struct {
+	url       string
+	maxLength int
+}
+
+// NewWebhook returns a channel posting the text of messages to an incoming webhook
+// compatible with Slack and Microsoft Teams, which both accept {"text": "..."}
+func NewWebhook(url string, maxLength int) job.Notifier {
+	return &webhookChannel{url, maxLength}
+}
+
+// Notify posts the text of the message
+func (c *webhookChannel) Notify(message *job.Message) error {
+	return postJSON(c.url, "", map[string]string{
+		"text": truncate(message.Text, c.maxLength),
+	})
+}
+
+type matrixChannel struct {
+	url       string
+	token     string
+	username  string
+	maxLength int
+}
+
+// NewMatrix returns a channel posting the text of messages to a Matrix or XMPP bridge webhook,
+// e.g. generic webhooks of matrix-hookshot, which accept {"text": "...", "username": "..."}
+func NewMatrix(url, token, username string, maxLength int) job.Notifier {
+	return &matrixChannel{url, token, username, maxLength}
+}
+
+// Notify posts the text of the message
+func (c *matrixChannel) Notify(message *job.Message) error {
+	return postJSON(c.url, c.token, map[string]string{
+		"text":     truncate(message.Text, c.maxLength),
+		"username": c.username,
+	})
+}
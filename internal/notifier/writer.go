@@ -0,0 +1,91 @@
+package notifier
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/emed-appts/emed-mailer/internal/job"
+	"github.com/emed-appts/emed-mailer/internal/mailer"
+
+	"github.com/pkg/errors"
+)
+
+// output formats of Writer
+const (
+	FormatHTML = "html"
+	FormatEML  = "eml"
+)
+
+// WriterConfig struct encapsulate the settings of a Writer
+type WriterConfig struct {
+	// Name prefixes the written files, e.g. the job name
+	Name string
+	// Format is html (the message body) or eml (the whole mail)
+	Format string
+	// Dir receives a file per message, Out is used if empty
+	Dir string
+	Out io.Writer
+	// Mail provides sender, recipients and subject of eml
+	Mail mailer.Config
+}
+
+// Writer writes messages instead of sending them, e.g. to preview them
+// it replaces every channel of a job as well as the mailer of patient notifications
+type Writer struct {
+	cfg WriterConfig
+
+	mu    sync.Mutex
+	count int
+}
+
+// NewWriter returns a Writer
+func NewWriter(cfg WriterConfig) *Writer {
+	return &Writer{cfg: cfg}
+}
+
+// Notify writes the message
+func (w *Writer) Notify(message *job.Message) error {
+	return w.SendMessageTo("", message.Subject, message.ContentType, message.Body)
+}
+
+// SendMessageTo writes the message to `to`
+func (w *Writer) SendMessageTo(to, subject, contentType, messageText string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.count++
+
+	out := w.cfg.Out
+	if w.cfg.Dir != "" {
+		if err := os.MkdirAll(w.cfg.Dir, os.ModePerm); err != nil {
+			return errors.Wrap(err, "could not create output directory")
+		}
+
+		name := fmt.Sprintf("%s-%s-%d.%s", w.cfg.Name, time.Now().Format("20060102-150405"), w.count, w.cfg.Format)
+		f, err := os.Create(filepath.Join(w.cfg.Dir, name))
+		if err != nil {
+			return errors.Wrap(err, "could not create output file")
+		}
+		defer f.Close()
+		out = f
+	}
+
+	if w.cfg.Format == FormatEML {
+		return mailer.WriteMessage(out, w.cfg.Mail, to, subject, contentType, messageText)
+	}
+
+	_, err := io.WriteString(out, messageText)
+	return errors.Wrap(err, "could not write message")
+}
+
+// Count returns the number of written messages
+func (w *Writer) Count() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.count
+}
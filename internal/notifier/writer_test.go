@@ -0,0 +1,59 @@
+package notifier
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emed-appts/emed-mailer/internal/job"
+	"github.com/emed-appts/emed-mailer/internal/mailer"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriter_HTML(t *testing.T) {
+	out := &bytes.Buffer{}
+	w := NewWriter(WriterConfig{Name: "changes", Format: FormatHTML, Out: out})
+
+	err := w.Notify(&job.Message{Subject: "Changes", ContentType: "text/html", Body: "<p>body</p>"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "<p>body</p>", out.String())
+	assert.Equal(t, 1, w.Count())
+}
+
+func TestWriter_EML(t *testing.T) {
+	dir := t.TempDir()
+	w := NewWriter(WriterConfig{
+		Name:   "changes",
+		Format: FormatEML,
+		Dir:    dir,
+		Mail: mailer.Config{
+			From:    "mailer@example.com",
+			To:      "practice@example.com",
+			Subject: "Default",
+		},
+	})
+
+	assert.NoError(t, w.Notify(&job.Message{Subject: "Changes", ContentType: "text/html", Body: "<p>body</p>"}))
+	assert.NoError(t, w.SendMessageTo("patient@example.com", "", "text/html", "<p>reminder</p>"))
+
+	files, err := filepath.Glob(filepath.Join(dir, "changes-*.eml"))
+	assert.NoError(t, err)
+	if assert.Len(t, files, 2) {
+		var mails []string
+		for _, f := range files {
+			content, err := os.ReadFile(f)
+			assert.NoError(t, err)
+			mails = append(mails, string(content))
+		}
+		all := strings.Join(mails, "\n")
+
+		assert.Contains(t, all, "To: <practice@example.com>")
+		assert.Contains(t, all, "Subject: Changes")
+		assert.Contains(t, all, "To: <patient@example.com>")
+		assert.Contains(t, all, "Subject: Default")
+	}
+}
@@ -0,0 +1,225 @@
+package preview
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/emed-appts/emed-mailer/internal/job"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultUpcomingDays is the number of days of upcoming appointments if a fixture sets none
+const defaultUpcomingDays = 3
+
+// fixtureExtensions lists the supported fixture files
+var fixtureExtensions = []string{".json", ".yaml", ".yml"}
+
+// Change is a changed appointment of a fixture
+type Change struct {
+	Time        time.Time `json:"time" yaml:"time"`
+	Appointment time.Time `json:"appointment" yaml:"appointment"`
+	PatientID   int       `json:"patientID" yaml:"patientID"`
+	PatientName string    `json:"patientName" yaml:"patientName"`
+	IsBooking   bool      `json:"isBooking" yaml:"isBooking"`
+
+	// fields extracted by txt rules
+	Surname   string    `json:"surname" yaml:"surname"`
+	FirstName string    `json:"firstName" yaml:"firstName"`
+	BirthDate time.Time `json:"birthDate" yaml:"birthDate"`
+	Phone     string    `json:"phone" yaml:"phone"`
+	Comment   string    `json:"comment" yaml:"comment"`
+
+	// Error marks an entry which could not be interpreted
+	Error string `json:"error" yaml:"error"`
+
+	// Patient is the master data of an enriched change, optional
+	Patient *Patient `json:"patient" yaml:"patient"`
+}
+
+// Upcoming is a booked appointment of a fixture
+type Upcoming struct {
+	Appointment time.Time `json:"appointment" yaml:"appointment"`
+	BookedAt    time.Time `json:"bookedAt" yaml:"bookedAt"`
+	PatientID   int       `json:"patientID" yaml:"patientID"`
+	PatientName string    `json:"patientName" yaml:"patientName"`
+
+	// Patient is the master data of an enriched appointment, optional
+	Patient *Patient `json:"patient" yaml:"patient"`
+}
+
+// Patient is the master data of a patient of a fixture
+type Patient struct {
+	Phone      string `json:"phone" yaml:"phone"`
+	Insurance  string `json:"insurance" yaml:"insurance"`
+	NewPatient bool   `json:"newPatient" yaml:"newPatient"`
+}
+
+// patient converts the master data of patient `id`, nil stays nil
+func (p *Patient) patient(id int) *job.Patient {
+	if p == nil {
+		return nil
+	}
+	return &job.Patient{
+		ID:         id,
+		Phone:      p.Phone,
+		Insurance:  p.Insurance,
+		NewPatient: p.NewPatient,
+	}
+}
+
+// Fixture is sample data of the templates, read from a JSON or YAML file
+type Fixture struct {
+	// Name is the file name without extension
+	Name string `json:"-" yaml:"-"`
+
+	// LastRun is the start of the reported period
+	LastRun time.Time `json:"lastRun" yaml:"lastRun"`
+	// Now is the end of the reported period, defaults to the current time
+	Now time.Time `json:"now" yaml:"now"`
+	// UpcomingDays is the number of days of upcoming appointments, defaults to 3
+	UpcomingDays int `json:"upcomingDays" yaml:"upcomingDays"`
+
+	Changes  []*Change   `json:"changes" yaml:"changes"`
+	Upcoming []*Upcoming `json:"upcoming" yaml:"upcoming"`
+}
+
+// LoadFixture reads the fixture file `file`
+func LoadFixture(file string) (*Fixture, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read fixture")
+	}
+
+	ext := filepath.Ext(file)
+	f := &Fixture{Name: strings.TrimSuffix(filepath.Base(file), ext)}
+
+	if ext == ".json" {
+		err = json.Unmarshal(content, f)
+	} else {
+		err = yaml.Unmarshal(content, f)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not parse fixture %s", filepath.Base(file))
+	}
+
+	return f, nil
+}
+
+// FixtureFiles lists the fixture files in `dir` sorted by name
+func FixtureFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list fixtures")
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		for _, ext := range fixtureExtensions {
+			if filepath.Ext(entry.Name()) == ext {
+				files = append(files, filepath.Join(dir, entry.Name()))
+			}
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Data is passed to the templates
+// it provides the fields of all built-in templates, so every template can be rendered with every fixture
+type Data struct {
+	// changes and report
+	LastRun      time.Time
+	ChangedAppts []*job.ApptChange
+	Upcoming     []*job.UpcomingDay
+	Statistics   *job.Statistics
+	Unparsed     []*job.ApptChange
+
+	// patient notifications, taken from the first change
+	PatientID   int
+	PatientName string
+	Appointment time.Time
+
+	// alerts
+	Job          string
+	Failures     int
+	Error        string
+	FirstFailure time.Time
+	From         time.Time
+	To           time.Time
+}
+
+// Data converts the fixture to the data of the templates
+func (f *Fixture) Data() *Data {
+	now := f.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	days := f.UpcomingDays
+	if days == 0 {
+		days = defaultUpcomingDays
+	}
+
+	var changes, unparsed []*job.ApptChange
+	for _, c := range f.Changes {
+		change := &job.ApptChange{
+			Time:        c.Time,
+			Appointment: c.Appointment,
+			PatientID:   c.PatientID,
+			PatientName: c.PatientName,
+			IsBooking:   c.IsBooking,
+			Surname:     c.Surname,
+			FirstName:   c.FirstName,
+			BirthDate:   c.BirthDate,
+			Phone:       c.Phone,
+			Comment:     c.Comment,
+			Error:       c.Error,
+			Patient:     c.Patient.patient(c.PatientID),
+		}
+		if change.Error != "" {
+			unparsed = append(unparsed, change)
+			continue
+		}
+		changes = append(changes, change)
+	}
+	upcoming := make([]*job.UpcomingAppt, len(f.Upcoming))
+	for i, u := range f.Upcoming {
+		upcoming[i] = &job.UpcomingAppt{
+			Appointment: u.Appointment,
+			BookedAt:    u.BookedAt,
+			PatientID:   u.PatientID,
+			PatientName: u.PatientName,
+			Patient:     u.Patient.patient(u.PatientID),
+		}
+	}
+
+	data := &Data{
+		LastRun:      f.LastRun,
+		ChangedAppts: changes,
+		Upcoming:     job.GroupUpcoming(now, days, upcoming),
+		Statistics:   job.NewStatistics(f.LastRun, now, changes),
+		Unparsed:     unparsed,
+
+		Job:          f.Name,
+		Failures:     3,
+		Error:        "collect updated appointments failed: sample error",
+		FirstFailure: f.LastRun,
+		From:         f.LastRun,
+		To:           now,
+	}
+	if len(changes) > 0 {
+		data.PatientID = changes[0].PatientID
+		data.PatientName = changes[0].PatientName
+		data.Appointment = changes[0].Appointment
+	}
+
+	return data
+}
@@ -0,0 +1,98 @@
+package preview
+
+import (
+	htmltemplate "html/template"
+)
+
+// style is shared by the pages
+const style = `<style type="text/css">
+    body { font-family: Arial, Helvetica, sans-serif; margin: 1em 2em; }
+    table { border-collapse: collapse; }
+    th, td { text-align: left; padding: .25em .75em; border-bottom: 1px solid #ddd; }
+    .error { color: #b00020; white-space: pre-wrap; }
+    pre { background-color: #f4f4f4; padding: .5em; white-space: pre-wrap; }
+    .source { font-family: monospace; background-color: #f4f4f4; }
+    .source td { border: none; padding: 0 .5em; white-space: pre; }
+    .source .failed { background-color: #ffd6dc; }
+    iframe { width: 100%; height: 70vh; border: 1px solid #ddd; }
+</style>`
+
+var indexPage = htmltemplate.Must(htmltemplate.New("index").Parse(`<!doctype html>
+<html>
+<head>
+    <meta charset="utf-8" />
+    <title>emed-mailer templates</title>
+    ` + style + `
+</head>
+<body>
+<h1>Templates</h1>
+<p>Templates: {{ if .Dir }}{{ .Dir }}, {{ end }}embedded<br>Fixtures: {{ .FixtureDir }}</p>
+{{ if .Fixtures }}
+<table>
+    <thead>
+    <tr>
+        <th>Template</th>
+        {{ range .Fixtures }}<th>{{ .File }}</th>{{ end }}
+    </tr>
+    </thead>
+    <tbody>
+    {{ range $tmpl := .Templates }}
+    <tr>
+        <td>{{ $tmpl }}</td>
+        {{ range $.Fixtures }}<td>{{ if .Error }}<span class="error">invalid</span>{{ else }}<a href="/view?template={{ $tmpl }}&fixture={{ .Name }}">view</a>{{ end }}</td>{{ end }}
+    </tr>
+    {{ end }}
+    </tbody>
+</table>
+{{ range .Fixtures }}{{ if .Error }}
+<p class="error">{{ .File }}: {{ .Error }}</p>
+{{ end }}{{ end }}
+{{ else }}
+<p>No fixtures found, add JSON or YAML files to {{ .FixtureDir }}.</p>
+{{ end }}
+</body>
+</html>
+`))
+
+var viewPage = htmltemplate.Must(htmltemplate.New("view").Parse(`<!doctype html>
+<html>
+<head>
+    <meta charset="utf-8" />
+    <title>{{ .Template }} - {{ .Fixture.Name }}</title>
+    ` + style + `
+</head>
+<body>
+<p><a href="/">Templates</a> / {{ .Template }} / {{ .Fixture.File }}</p>
+{{ if .Fixture.Error }}
+<p class="error">{{ .Fixture.File }}: {{ .Fixture.Error }}</p>
+{{ else if .Error }}
+<p class="error">{{ .Error }}</p>
+{{ if .Source }}
+<table class="source">
+    {{ range .Source }}<tr{{ if .Failed }} class="failed" id="failed"{{ end }}><td>{{ .Number }}</td><td>{{ .Text }}</td></tr>
+    {{ end }}
+</table>
+{{ end }}
+{{ else }}
+<p><strong>Subject:</strong> {{ with .Rendered.Subject }}{{ . }}{{ else }}<em>not defined, the configured subject is used</em>{{ end }}</p>
+{{ if .Rendered.Text }}<p><strong>Text:</strong></p>
+<pre>{{ .Rendered.Text }}</pre>{{ end }}
+<iframe src="/render?template={{ .Template }}&fixture={{ .Fixture.Name }}"></iframe>
+{{ end }}
+<script>
+    // reload when a template or fixture changes
+    (function () {
+        var current;
+        setInterval(function () {
+            fetch("/version").then(function (r) { return r.text(); }).then(function (v) {
+                if (current !== undefined && v !== current) {
+                    location.reload();
+                }
+                current = v;
+            }).catch(function () {});
+        }, 1000);
+    })();
+</script>
+</body>
+</html>
+`))
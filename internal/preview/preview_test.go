@@ -0,0 +1,106 @@
+package preview
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emed-appts/emed-mailer/internal/template"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadFixture(t *testing.T) {
+	files, err := FixtureFiles("../../conf/fixtures")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, files)
+
+	for _, file := range files {
+		f, err := LoadFixture(file)
+		if assert.NoError(t, err, file) {
+			assert.False(t, f.LastRun.IsZero(), file)
+		}
+	}
+
+	f, err := LoadFixture("../../conf/fixtures/sample.yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, "sample", f.Name)
+	if assert.Len(t, f.Changes, 4) {
+		assert.Equal(t, 1001, f.Changes[0].PatientID)
+		assert.True(t, f.Changes[0].IsBooking)
+	}
+
+	data := f.Data()
+	assert.Equal(t, "Maria Huber", data.PatientName)
+	assert.Len(t, data.ChangedAppts, 3)
+	assert.Len(t, data.Unparsed, 1)
+	if assert.Len(t, data.Upcoming, 3) {
+		// today of the fixture has no appointments, tomorrow has one
+		assert.Empty(t, data.Upcoming[0].Appts)
+		assert.Len(t, data.Upcoming[1].Appts, 1)
+	}
+}
+
+func TestServer(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "broken.tmpl"), []byte("<p>\n{{ if .LastRun }}\n</p>\n"), 0o644))
+	template.SetDir(dir)
+	defer template.SetDir("")
+
+	fixtures := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(fixtures, "one.json"), []byte(`{
+		"lastRun": "2026-10-19T06:00:00+02:00",
+		"changes": [{"time": "2026-10-19T07:00:00+02:00", "appointment": "2026-10-21T09:30:00+02:00", "patientID": 1, "patientName": "Json Patient", "isBooking": true}]
+	}`), 0o644))
+	assert.NoError(t, os.WriteFile(filepath.Join(fixtures, "invalid.yaml"), []byte("changes: [\n"), 0o644))
+
+	server := httptest.NewServer(New(fixtures))
+	defer server.Close()
+
+	body := get(t, server.URL+"/")
+	assert.Contains(t, body, "broken.tmpl")
+	assert.Contains(t, body, "changedappts.tmpl")
+	assert.Contains(t, body, "invalid.yaml: ")
+
+	body = get(t, server.URL+"/render?template=changedappts.tmpl&fixture=one")
+	assert.Contains(t, body, "Json Patient")
+
+	// parse errors show the failing line
+	body = get(t, server.URL+"/view?template=broken.tmpl&fixture=one")
+	assert.Contains(t, body, "broken.tmpl:4: unexpected EOF")
+	assert.Contains(t, body, `<tr class="failed" id="failed"><td>4</td>`)
+
+	// only listed templates are rendered
+	assert.NoError(t, os.WriteFile(filepath.Join(fixtures, "secret.tmpl"), []byte("secret"), 0o644))
+	for _, name := range []string{"../" + filepath.Base(fixtures) + "/secret.tmpl", "..%5Csecret.tmpl", "unknown.tmpl"} {
+		resp, err := http.Get(server.URL + "/render?fixture=one&template=" + name)
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode, name)
+		}
+	}
+
+	// the version changes with the fixtures
+	version := get(t, server.URL+"/version")
+	later := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(filepath.Join(fixtures, "one.json"), later, later))
+	assert.NotEqual(t, version, get(t, server.URL+"/version"))
+}
+
+func get(t *testing.T, url string) string {
+	resp, err := http.Get(url)
+	if !assert.NoError(t, err) {
+		return ""
+	}
+	defer resp.Body.Close()
+
+	buf := new(strings.Builder)
+	_, err = io.Copy(buf, resp.Body)
+	assert.NoError(t, err)
+	return buf.String()
+}
@@ -0,0 +1,262 @@
+package preview
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emed-appts/emed-mailer/internal/template"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog/log"
+)
+
+// errorLine finds the line of a template error, e.g. "template: changedappts.tmpl:12: unexpected EOF"
+var errorLine = regexp.MustCompile(`template: [^:\s]+:(\d+)`)
+
+// fixtureResult is a fixture or the error loading it
+type fixtureResult struct {
+	Name    string
+	File    string
+	Fixture *Fixture
+	Error   string
+}
+
+// sourceLine is a line of the template source shown with errors
+type sourceLine struct {
+	Number int
+	Text   string
+	Failed bool
+}
+
+type server struct {
+	fixtures string
+}
+
+// New returns the handler of the template preview
+// it renders the templates of the template package against the fixtures in directory `fixtures`,
+// templates and fixtures are read on every request, so changes show up immediately
+// /         lists the templates and fixtures
+// /view     shows subject, text and body of a template rendered with a fixture, or the error
+// /render   responds the rendered body
+// /version  changes whenever a template or fixture changes, the view polls it to reload
+func New(fixtures string) http.Handler {
+	s := &server{fixtures}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.index)
+	mux.HandleFunc("/view", s.view)
+	mux.HandleFunc("/render", s.render)
+	mux.HandleFunc("/version", s.version)
+	return mux
+}
+
+// index lists the templates and fixtures
+func (s *server) index(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	templates, err := template.List()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fixtures, err := s.loadFixtures()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.page(w, indexPage, map[string]interface{}{
+		"Dir":        template.Dir(),
+		"Fixtures":   fixtures,
+		"FixtureDir": s.fixtures,
+		"Templates":  templates,
+	})
+}
+
+// view shows a template rendered with a fixture
+func (s *server) view(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("template")
+	if err := checkTemplate(name); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	fixture, err := s.findFixture(r.URL.Query().Get("fixture"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Template": name,
+		"Fixture":  fixture,
+	}
+
+	if fixture.Error == "" {
+		rendered, err := template.Render(name, fixture.Fixture.Data())
+		if err != nil {
+			data["Error"] = errors.Cause(err).Error()
+			data["Source"] = source(name, err)
+		} else {
+			data["Rendered"] = rendered
+		}
+	}
+
+	s.page(w, viewPage, data)
+}
+
+// render responds the body of a template rendered with a fixture
+func (s *server) render(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("template")
+	if err := checkTemplate(name); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	fixture, err := s.findFixture(r.URL.Query().Get("fixture"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if fixture.Error != "" {
+		http.Error(w, fixture.Error, http.StatusUnprocessableEntity)
+		return
+	}
+
+	rendered, err := template.Render(name, fixture.Fixture.Data())
+	if err != nil {
+		http.Error(w, errors.Cause(err).Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write([]byte(rendered.Body))
+}
+
+// version responds the latest modification of the templates and fixtures
+func (s *server) version(w http.ResponseWriter, r *http.Request) {
+	var latest time.Time
+	count := 0
+	for _, dir := range []string{template.Dir(), s.fixtures} {
+		if dir == "" {
+			continue
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			count++
+			if info.ModTime().After(latest) {
+				latest = info.ModTime()
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	fmt.Fprintf(w, "%d-%d", latest.UnixNano(), count)
+}
+
+// loadFixtures loads all fixtures, fixtures which can not be parsed report their error
+func (s *server) loadFixtures() ([]*fixtureResult, error) {
+	files, err := FixtureFiles(s.fixtures)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*fixtureResult, len(files))
+	for i, file := range files {
+		result := &fixtureResult{
+			Name: strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)),
+			File: filepath.Base(file),
+		}
+		result.Fixture, err = LoadFixture(file)
+		if err != nil {
+			result.Error = errors.Cause(err).Error()
+		}
+		results[i] = result
+	}
+	return results, nil
+}
+
+// checkTemplate accepts the names listed by the template package only,
+// so no files outside the template directory can be read
+func checkTemplate(name string) error {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.Contains(name, "..") {
+		return errors.Errorf("invalid template name %q", name)
+	}
+
+	templates, err := template.List()
+	if err != nil {
+		return err
+	}
+	for _, t := range templates {
+		if t == name {
+			return nil
+		}
+	}
+	return errors.Errorf("unknown template %q", name)
+}
+
+// findFixture loads the fixture `name`
+func (s *server) findFixture(name string) (*fixtureResult, error) {
+	fixtures, err := s.loadFixtures()
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range fixtures {
+		if f.Name == name {
+			return f, nil
+		}
+	}
+	return nil, errors.Errorf("unknown fixture %q", name)
+}
+
+// page executes a page template
+func (s *server) page(w http.ResponseWriter, t *htmltemplate.Template, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := t.Execute(w, data); err != nil {
+		log.Error().
+			Err(err).
+			Msg("could not execute preview page")
+	}
+}
+
+// source returns the lines of template `name` and marks the line of `err`
+// it returns nil if the error has no line, e.g. for unknown templates
+func source(name string, err error) []*sourceLine {
+	match := errorLine.FindStringSubmatch(err.Error())
+	if match == nil {
+		return nil
+	}
+	failed, _ := strconv.Atoi(match[1])
+
+	text, err := template.Source(name)
+	if err != nil {
+		return nil
+	}
+
+	lines := strings.Split(text, "\n")
+	source := make([]*sourceLine, len(lines))
+	for i, line := range lines {
+		source[i] = &sourceLine{
+			Number: i + 1,
+			Text:   line,
+			Failed: i+1 == failed,
+		}
+	}
+	return source
+}
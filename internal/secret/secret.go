@@ -0,0 +1,82 @@
+package secret
+
+import (
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/zalando/go-keyring"
+)
+
+// supported reference schemes
+const (
+	schemeEnv     = "env:"
+	schemeFile    = "file:"
+	schemeKeyring = "keyring:"
+)
+
+// Resolve returns the secret referenced by `value`
+// supported references are
+//   - env:NAME reads the environment variable NAME
+//   - file:/path reads the file, trailing newlines are removed
+//   - keyring:service/user reads the OS keyring (Windows Credential Manager, macOS Keychain, Secret Service)
+//
+// any other value is returned as is
+func Resolve(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, schemeEnv):
+		name := strings.TrimPrefix(value, schemeEnv)
+		secret, ok := os.LookupEnv(name)
+		if !ok {
+			return "", errors.Errorf("environment variable %q is not set", name)
+		}
+		return secret, nil
+
+	case strings.HasPrefix(value, schemeFile):
+		name := strings.TrimPrefix(value, schemeFile)
+		content, err := os.ReadFile(name)
+		if err != nil {
+			return "", errors.Wrapf(err, "could not read secret file %q", name)
+		}
+		return strings.TrimRight(string(content), "\r\n"), nil
+
+	case strings.HasPrefix(value, schemeKeyring):
+		service, user, err := ParseKeyringRef(strings.TrimPrefix(value, schemeKeyring))
+		if err != nil {
+			return "", err
+		}
+		secret, err := keyring.Get(service, user)
+		if err != nil {
+			return "", errors.Wrapf(err, "could not read keyring entry %s/%s", service, user)
+		}
+		return secret, nil
+	}
+
+	return value, nil
+}
+
+// IsReference reports if `value` references a secret instead of containing it
+func IsReference(value string) bool {
+	return strings.HasPrefix(value, schemeEnv) ||
+		strings.HasPrefix(value, schemeFile) ||
+		strings.HasPrefix(value, schemeKeyring)
+}
+
+// Store saves `secret` in the OS keyring
+func Store(service, user, secret string) error {
+	return errors.Wrapf(keyring.Set(service, user, secret), "could not write keyring entry %s/%s", service, user)
+}
+
+// Delete removes a secret from the OS keyring
+func Delete(service, user string) error {
+	return errors.Wrapf(keyring.Delete(service, user), "could not delete keyring entry %s/%s", service, user)
+}
+
+// ParseKeyringRef splits a keyring reference `service/user`
+func ParseKeyringRef(ref string) (string, string, error) {
+	parts := strings.SplitN(ref, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", errors.Errorf("invalid keyring reference %q, expected service/user", ref)
+	}
+	return parts[0], parts[1], nil
+}
@@ -0,0 +1,44 @@
+package secret
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolve(t *testing.T) {
+	t.Setenv("EMED_TEST_SECRET", "from-env")
+
+	file := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(file, []byte("from-file\r\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		value    string
+		expected string
+		err      bool
+	}{
+		{value: "plaintext", expected: "plaintext"},
+		{value: "", expected: ""},
+		{value: "env:EMED_TEST_SECRET", expected: "from-env"},
+		{value: "env:EMED_TEST_SECRET_MISSING", err: true},
+		{value: "file:" + file, expected: "from-file"},
+		{value: "file:" + file + ".missing", err: true},
+		{value: "keyring:invalid", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			resolved, err := Resolve(tt.value)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, resolved)
+		})
+	}
+}
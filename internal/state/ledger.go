@@ -0,0 +1,95 @@
+package state
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ledgerRetention is the time entries are kept
+const ledgerRetention = 90 * 24 * time.Hour
+
+// Ledger records which notifications have been sent
+// entries are stored in a JSON file and expire after some time
+type Ledger struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[string]time.Time
+}
+
+// NewLedger returns the ledger `name` stored in directory `root`
+func NewLedger(root, name string) *Ledger {
+	return &Ledger{
+		path: path.Join(root, name+".sent.json"),
+	}
+}
+
+// Sent reports if `key` has been recorded
+func (l *Ledger) Sent(key string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.load(); err != nil {
+		return false, err
+	}
+
+	_, ok := l.entries[key]
+	return ok, nil
+}
+
+// Record stores `key` as sent at `at`
+func (l *Ledger) Record(key string, at time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.load(); err != nil {
+		return err
+	}
+
+	l.entries[key] = at
+	for k, t := range l.entries {
+		if at.Sub(t) > ledgerRetention {
+			delete(l.entries, k)
+		}
+	}
+
+	content, err := json.MarshalIndent(l.entries, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "could not encode ledger")
+	}
+
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0666); err != nil {
+		return errors.Wrap(err, "could not write ledger")
+	}
+	return errors.Wrap(os.Rename(tmp, l.path), "could not replace ledger")
+}
+
+// load reads the ledger file once
+func (l *Ledger) load() error {
+	if l.entries != nil {
+		return nil
+	}
+
+	content, err := os.ReadFile(l.path)
+	if os.IsNotExist(err) {
+		l.entries = map[string]time.Time{}
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "could not read ledger")
+	}
+
+	entries := map[string]time.Time{}
+	if err := json.Unmarshal(content, &entries); err != nil {
+		return errors.Wrap(err, "could not parse ledger")
+	}
+	l.entries = entries
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package state
+
+import (
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Watermark persists the time of the last successful job run in a file
+// so a restarted service continues where it stopped
+type Watermark struct {
+	path string
+}
+
+// New returns the watermark `name` stored in directory `root`
+func New(root, name string) *Watermark {
+	return &Watermark{
+		path: path.Join(root, name+".lastrun"),
+	}
+}
+
+// Load returns the stored time, or zero time if nothing has been stored yet
+func (w *Watermark) Load() (time.Time, error) {
+	content, err := os.ReadFile(w.path)
+	if os.IsNotExist(err) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "could not read watermark")
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(content)))
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "could not parse watermark")
+	}
+	return t, nil
+}
+
+// Save stores `t`
+// the file is replaced atomically, so a crash never leaves a broken watermark
+func (w *Watermark) Save(t time.Time) error {
+	tmp := w.path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(t.Format(time.RFC3339Nano)+"\n"), 0666); err != nil {
+		return errors.Wrap(err, "could not write watermark")
+	}
+
+	return errors.Wrap(os.Rename(tmp, w.path), "could not replace watermark")
+}
@@ -1,32 +1,238 @@
 package template
 
 import (
-	_ "embed"
+	"embed"
+	"fmt"
+	"html"
 	"html/template"
 	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
 )
 
+// blocksName is the file of the blocks shared by the templates, e.g. {{template "unparsed" .Unparsed}}
+const blocksName = "blocks.tmpl"
+
 var (
-	//go:embed changedappts.tmpl
-	msgTemplate string
+	//go:embed *.tmpl
+	embedded embed.FS
+
+	// customDir contains custom templates, they take precedence over the embedded ones
+	customDir   string
+	customDirMu sync.RWMutex
+
+	// location is the time zone of formatted times, nil keeps the time zone of the times
+	location   *time.Location
+	locationMu sync.RWMutex
+
+	weekdays = [...]string{"Sonntag", "Montag", "Dienstag", "Mittwoch", "Donnerstag", "Freitag", "Samstag"}
 
 	funcMap = template.FuncMap{
 		"DateFmt": func(t time.Time) string {
-			return t.Format("02.01.2006 15:04")
+			return inLocation(t).Format("02.01.2006 15:04")
+		},
+		"TimeFmt": func(t time.Time) string {
+			return inLocation(t).Format("15:04")
+		},
+		"DayFmt": func(t time.Time) string {
+			return inLocation(t).Format("02.01.2006")
+		},
+		"WeekdayFmt": func(d time.Weekday) string {
+			return weekdays[d]
+		},
+		"DurationFmt": func(d time.Duration) string {
+			days := int(d.Hours()) / 24
+			hours := int(d.Hours()) % 24
+			if days == 0 {
+				return fmt.Sprintf("%d Std.", hours)
+			}
+			return fmt.Sprintf("%d T. %d Std.", days, hours)
+		},
+		"Percent": func(f float64) string {
+			return fmt.Sprintf("%.1f %%", f*100)
 		},
 	}
 )
 
+// SetDir sets the directory of custom templates
+func SetDir(dir string) {
+	customDirMu.Lock()
+	customDir = dir
+	customDirMu.Unlock()
+}
+
+// Dir returns the directory of custom templates
+func Dir() string {
+	customDirMu.RLock()
+	defer customDirMu.RUnlock()
+
+	return customDir
+}
+
+// SetLocation sets the time zone of formatted times
+func SetLocation(loc *time.Location) {
+	locationMu.Lock()
+	location = loc
+	locationMu.Unlock()
+}
+
+// inLocation returns `t` in the time zone of formatted times
+func inLocation(t time.Time) time.Time {
+	locationMu.RLock()
+	defer locationMu.RUnlock()
+
+	if location == nil {
+		return t
+	}
+	return t.In(location)
+}
+
+// Exists checks if template `name` is available in `dir` or embedded
+func Exists(dir, name string) bool {
+	_, err := load(dir, name)
+	return err == nil
+}
+
+// List returns the names of the templates available in the custom directory or embedded
+func List() ([]string, error) {
+	names := map[string]bool{}
+
+	embeddedNames, err := fs.Glob(embedded, "*.tmpl")
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list embedded templates")
+	}
+	for _, name := range embeddedNames {
+		names[name] = true
+	}
+	delete(names, blocksName)
+
+	if dir := Dir(); dir != "" {
+		customNames, err := filepath.Glob(filepath.Join(dir, "*.tmpl"))
+		if err != nil {
+			return nil, errors.Wrap(err, "could not list custom templates")
+		}
+		for _, name := range customNames {
+			names[filepath.Base(name)] = true
+		}
+		delete(names, blocksName)
+	}
+
+	list := make([]string, 0, len(names))
+	for name := range names {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list, nil
+}
+
+// Source returns the text of the named template
+func Source(name string) (string, error) {
+	return load(Dir(), name)
+}
+
 // Execute executes the named template
 func Execute(wr io.Writer, name string, data interface{}) error {
-	t, err := template.New("message").Funcs(funcMap).Parse(msgTemplate)
+	t, err := parse(name)
 	if err != nil {
-		return errors.Wrap(err, "could not parse template")
+		return err
 	}
 
 	err = t.Execute(wr, data)
 	return errors.Wrap(err, "could not execute template")
 }
+
+// Rendered is the output of a template
+type Rendered struct {
+	// Subject is defined by the template using {{define "subject"}}, it is empty otherwise
+	Subject string
+	// Text is a short plain text version for channels like SMS or chat,
+	// defined by the template using {{define "text"}}, it is empty otherwise
+	Text string
+	// Body is the message
+	Body string
+}
+
+// Render executes the named template and its subject and text blocks
+func Render(name string, data interface{}) (*Rendered, error) {
+	t, err := parse(name)
+	if err != nil {
+		return nil, err
+	}
+
+	rendered := &Rendered{}
+	if rendered.Subject, err = executeBlock(t, "subject", data); err != nil {
+		return nil, err
+	}
+	if rendered.Text, err = executeBlock(t, "text", data); err != nil {
+		return nil, err
+	}
+
+	buf := new(strings.Builder)
+	if err := t.Execute(buf, data); err != nil {
+		return nil, errors.Wrap(err, "could not execute template")
+	}
+	rendered.Body = buf.String()
+
+	return rendered, nil
+}
+
+// executeBlock executes a block defined by the template as plain text
+// blocks not defined by the template are empty
+func executeBlock(t *template.Template, name string, data interface{}) (string, error) {
+	bt := t.Lookup(name)
+	if bt == nil {
+		return "", nil
+	}
+
+	buf := new(strings.Builder)
+	if err := bt.Execute(buf, data); err != nil {
+		return "", errors.Wrapf(err, "could not execute %s template", name)
+	}
+	return strings.TrimSpace(html.UnescapeString(buf.String())), nil
+}
+
+// parse loads and parses the named template along with the shared blocks
+func parse(name string) (*template.Template, error) {
+	blocks, err := load(Dir(), blocksName)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not load shared blocks")
+	}
+	text, err := load(Dir(), name)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not load template")
+	}
+
+	t, err := template.New(name).Funcs(funcMap).Parse(blocks)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse shared blocks")
+	}
+	t, err = t.Parse(text)
+	return t, errors.Wrap(err, "could not parse template")
+}
+
+// load reads template `name` from `dir`, falls back to the embedded templates
+func load(dir, name string) (string, error) {
+	if dir != "" {
+		content, err := os.ReadFile(path.Join(dir, name))
+		if err == nil {
+			return string(content), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", errors.Wrapf(err, "could not read template %s", name)
+		}
+	}
+
+	content, err := embedded.ReadFile(name)
+	if err != nil {
+		return "", errors.Errorf("unknown template %s", name)
+	}
+	return string(content), nil
+}
@@ -22,15 +22,16 @@ func PrepareTestEnvironment(m *testing.M, pathToRoot string) {
 	config.AppWorkPath = path.Join(pathToRoot, "test")
 
 	// setup test config
-	config.General.Root = path.Join(config.AppWorkPath, "data")
-	if err := os.MkdirAll(config.General.Root, os.ModePerm); err != nil {
+	root := path.Join(config.AppWorkPath, "data")
+	config.Current().General.Root = root
+	if err := os.MkdirAll(root, os.ModePerm); err != nil {
 		fatalTestError("could not create folders of root path: %v", err)
 	}
 
 	exitCode := m.Run()
 
 	// cleanup generated data
-	if err := os.RemoveAll(config.General.Root); err != nil {
+	if err := os.RemoveAll(root); err != nil {
 		fatalTestError("cleanup root folder failed: %v", err)
 	}
 